@@ -0,0 +1,101 @@
+// Package atomicfile writes files so that readers never see a partially written file,
+// and concurrent writers don't interleave.
+package atomicfile
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Write writes path with write, holding the lock of path.
+// The content goes to a temp file in the same directory, which is renamed to path only if write succeeds.
+func Write(path string, write func(io.Writer) error) error {
+	return WritePath(path, writeTo(write))
+}
+
+// WriteLocked is Write for callers already holding Lock(path), e.g. to read, update and write path under one lock.
+// Calling Write instead would block on the lock held by the caller.
+func WriteLocked(path string, write func(io.Writer) error) error {
+	return writePath(path, writeTo(write))
+}
+
+func writeTo(write func(io.Writer) error) func(tmp string) error {
+	return func(tmp string) error {
+		f, err := os.OpenFile(tmp, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := write(f); err != nil {
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("failed to sync %s: %s", tmp, err)
+		}
+		return f.Close()
+	}
+}
+
+// WritePath is Write for writers that need a file path, like databases.
+// write creates the content at tmp, an empty file in the same directory as path.
+func WritePath(path string, write func(tmp string) error) error {
+	unlock, err := Lock(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return writePath(path, write)
+}
+
+func writePath(path string, write func(tmp string) error) (err error) {
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %s", err)
+	}
+	tmp := f.Name()
+	f.Close()
+	defer func() {
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+
+	if err := write(tmp); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, mode); err != nil {
+		return fmt.Errorf("failed to chmod %s: %s", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to rename %s to %s: %s", tmp, path, err)
+	}
+	return nil
+}
+
+// Lock blocks until it acquires the advisory exclusive lock of path, and returns the func to release it.
+// The lock is taken on path + ".lock", so that it survives the rename of path.
+func Lock(path string) (func(), error) {
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %s", err)
+	}
+
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock %s: %s", f.Name(), err)
+	}
+
+	return func() {
+		_ = unlockFile(f)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,152 @@
+package atomicfile
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWrite(t *testing.T) {
+	cases := []struct {
+		name    string
+		write   func(io.Writer) error
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "success",
+			write: func(w io.Writer) error {
+				_, err := io.WriteString(w, "new")
+				return err
+			},
+			want: "new",
+		},
+		{
+			name: "failure keeps the original",
+			write: func(w io.Writer) error {
+				_, _ = io.WriteString(w, "partial")
+				return errors.New("interrupted")
+			},
+			want:    "original",
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "cache.toml")
+			if err := os.WriteFile(path, []byte("original"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			err := Write(path, c.write)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("got err: %v, wantErr: %v", err, c.wantErr)
+			}
+
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != c.want {
+				t.Errorf("got: %s, want: %s", got, c.want)
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0o600 {
+				t.Errorf("got mode: %v, want: %v", info.Mode().Perm(), os.FileMode(0o600))
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				if strings.Contains(e.Name(), ".tmp-") {
+					t.Errorf("temp file is left: %s", e.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestWriteConcurrently(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.toml")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := Write(path, func(w io.Writer) error {
+				for j := 0; j < 100; j++ {
+					if _, err := fmt.Fprintf(w, "%d\n", i); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(got)), "\n")
+	if len(lines) != 100 {
+		t.Fatalf("got %d lines, want 100", len(lines))
+	}
+	for _, l := range lines {
+		if l != lines[0] {
+			t.Fatalf("writes are interleaved: %s and %s", lines[0], l)
+		}
+	}
+}
+
+func TestWriteLocked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.toml")
+	unlock, err := Lock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- WriteLocked(path, func(w io.Writer) error {
+			_, err := io.WriteString(w, "new")
+			return err
+		})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WriteLocked blocked on the lock held by the caller")
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "new" {
+		t.Errorf("got: %s, want: new", got)
+	}
+}
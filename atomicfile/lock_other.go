@@ -0,0 +1,14 @@
+//go:build !unix && !windows
+
+package atomicfile
+
+import "os"
+
+// advisory locks are not supported, so writes are only atomic
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
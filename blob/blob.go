@@ -0,0 +1,75 @@
+// Package blob reads and writes objects in object storage, like Google Cloud Storage,
+// so that a cache can be shared by a team.
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrNotExist is returned for objects that don't exist.
+var ErrNotExist = errors.New("object does not exist")
+
+// Attrs is the metadata of an object.
+type Attrs struct {
+	Updated  time.Time         // when the object is written
+	Metadata map[string]string // user-defined metadata
+}
+
+// Bucket stores objects by name.
+type Bucket interface {
+	Attrs(ctx context.Context, name string) (Attrs, error)
+	NewReader(ctx context.Context, name string) (io.ReadCloser, error)
+	// Write replaces the object with the content of r and metadata at once.
+	Write(ctx context.Context, name string, r io.Reader, metadata map[string]string) error
+}
+
+// IsURL reports whether location is an object URL rather than a local file path.
+func IsURL(location string) bool {
+	return strings.HasPrefix(location, "gs://") || strings.HasPrefix(location, "file://")
+}
+
+// Open returns the bucket and the object name of the URL, which is either
+// gs://bucket/name for Google Cloud Storage or file:///dir/name for a local directory.
+func Open(ctx context.Context, rawURL string) (Bucket, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid object URL %s: %s", rawURL, err)
+	}
+
+	switch u.Scheme {
+	case "gs":
+		name := strings.TrimPrefix(u.Path, "/")
+		if u.Host == "" || name == "" {
+			return nil, "", fmt.Errorf("invalid object URL %s: must be gs://bucket/name", rawURL)
+		}
+		b, err := NewGCSBucket(ctx, u.Host)
+		if err != nil {
+			return nil, "", err
+		}
+		return b, name, nil
+	case "file":
+		dir, name := splitPath(u.Path)
+		if name == "" {
+			return nil, "", fmt.Errorf("invalid object URL %s: must be file:///dir/name", rawURL)
+		}
+		return &DirBucket{Dir: dir}, name, nil
+	}
+	return nil, "", fmt.Errorf("unsupported object URL %s (must be gs:// or file://)", rawURL)
+}
+
+func splitPath(p string) (string, string) {
+	i := strings.LastIndex(p, "/")
+	if i < 0 {
+		return ".", p
+	}
+	if i == 0 {
+		return "/", p[1:]
+	}
+	return p[:i], p[i+1:]
+}
@@ -0,0 +1,160 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	cases := []struct {
+		url      string
+		wantDir  string
+		wantName string
+		wantErr  bool
+	}{
+		{url: "file:///var/cache/bqiam.json.gz", wantDir: "/var/cache", wantName: "bqiam.json.gz"},
+		{url: "file:///bqiam.toml", wantDir: "/", wantName: "bqiam.toml"},
+		{url: "file:///var/cache/", wantErr: true},
+		{url: "gs://bucket", wantErr: true},
+		{url: "s3://bucket/bqiam.toml", wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.url, func(t *testing.T) {
+			b, name, err := Open(context.Background(), c.url)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("got err: %v, wantErr: %v", err, c.wantErr)
+			}
+			if c.wantErr {
+				return
+			}
+			if dir := b.(*DirBucket).Dir; dir != c.wantDir {
+				t.Errorf("dir got: %s, want: %s", dir, c.wantDir)
+			}
+			if name != c.wantName {
+				t.Errorf("name got: %s, want: %s", name, c.wantName)
+			}
+		})
+	}
+}
+
+func TestIsURL(t *testing.T) {
+	for location, want := range map[string]bool{
+		"gs://bucket/cache.toml": true,
+		"file:///tmp/cache.toml": true,
+		"/tmp/cache.toml":        false,
+		"~/cache.toml":           false,
+	} {
+		if got := IsURL(location); got != want {
+			t.Errorf("IsURL(%s) got: %v, want: %v", location, got, want)
+		}
+	}
+}
+
+func TestDirBucket(t *testing.T) {
+	ctx := context.Background()
+	b := &DirBucket{Dir: t.TempDir()}
+
+	if _, err := b.Attrs(ctx, "cache.toml"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("got err: %v, want: %v", err, ErrNotExist)
+	}
+	if _, err := b.NewReader(ctx, "cache.toml"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("got err: %v, want: %v", err, ErrNotExist)
+	}
+
+	metadata := map[string]string{"key": "value"}
+	if err := b.Write(ctx, "cache.toml", strings.NewReader("content"), metadata); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	attrs, err := b.Attrs(ctx, "cache.toml")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(attrs.Metadata, metadata) {
+		t.Errorf("metadata got: %v, want: %v", attrs.Metadata, metadata)
+	}
+	if attrs.Updated.IsZero() {
+		t.Error("updated time is not set")
+	}
+
+	r, err := b.NewReader(ctx, "cache.toml")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(got) != "content" {
+		t.Errorf("content got: %s, want: content", got)
+	}
+
+	if matches, _ := filepath.Glob(filepath.Join(b.Dir, "*.tmp-*")); len(matches) > 0 {
+		t.Errorf("temp files are left: %v", matches)
+	}
+}
+
+// failingReader fails to read the content.
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestDirBucketWriteFailure(t *testing.T) {
+	ctx := context.Background()
+	b := &DirBucket{Dir: t.TempDir()}
+
+	old := map[string]string{"created": "old"}
+	if err := b.Write(ctx, "cache.toml", strings.NewReader("old content"), old); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := b.Write(ctx, "cache.toml", failingReader{}, map[string]string{"created": "new"}); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// the metadata must stay with the old content
+	attrs, err := b.Attrs(ctx, "cache.toml")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(attrs.Metadata, old) {
+		t.Errorf("metadata got: %v, want: %v", attrs.Metadata, old)
+	}
+}
+
+func TestDirBucketWriteMetadataFailure(t *testing.T) {
+	ctx := context.Background()
+	b := &DirBucket{Dir: t.TempDir()}
+
+	if err := b.Write(ctx, "cache.toml", strings.NewReader("old content"), map[string]string{"created": "old"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the metadata can't replace a directory
+	attrs := filepath.Join(b.Dir, "cache.toml.attrs.json")
+	if err := os.Remove(attrs); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(attrs, "dir"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Write(ctx, "cache.toml", strings.NewReader("new content"), map[string]string{"created": "new"}); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// the new content must not be left without its metadata
+	if _, err := b.Attrs(ctx, "cache.toml"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("got err: %v, want: %v", err, ErrNotExist)
+	}
+}
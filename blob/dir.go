@@ -0,0 +1,83 @@
+package blob
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/hirosassa/bqiam/atomicfile"
+)
+
+// DirBucket is a local directory standing in for a bucket, e.g. a directory synced between machines or for testing.
+// The metadata of an object is stored next to it, in name.attrs.json.
+// Write is not atomic across the two files: it replaces the content before the metadata,
+// so that new metadata is never seen with old content, and removes the content if the metadata fails to be written,
+// so that new content is not left with old metadata.
+type DirBucket struct {
+	Dir string
+}
+
+func (b *DirBucket) Attrs(ctx context.Context, name string) (Attrs, error) {
+	info, err := os.Stat(b.path(name))
+	if err != nil {
+		return Attrs{}, b.wrap(name, err)
+	}
+
+	attrs := Attrs{Updated: info.ModTime()}
+	data, err := os.ReadFile(b.path(name) + ".attrs.json")
+	if errors.Is(err, fs.ErrNotExist) {
+		return attrs, nil
+	}
+	if err != nil {
+		return Attrs{}, b.wrap(name, err)
+	}
+	if err := json.Unmarshal(data, &attrs.Metadata); err != nil {
+		return Attrs{}, fmt.Errorf("failed to parse metadata of %s: %s", b.path(name), err)
+	}
+	return attrs, nil
+}
+
+func (b *DirBucket) NewReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	f, err := os.Open(b.path(name))
+	if err != nil {
+		return nil, b.wrap(name, err)
+	}
+	return f, nil
+}
+
+func (b *DirBucket) Write(ctx context.Context, name string, r io.Reader, metadata map[string]string) error {
+	err := atomicfile.Write(b.path(name), func(w io.Writer) error {
+		_, err := io.Copy(w, r)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %s", b.path(name), err)
+	}
+
+	err = atomicfile.Write(b.path(name)+".attrs.json", func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(metadata)
+	})
+	if err != nil {
+		if rmErr := os.Remove(b.path(name)); rmErr != nil {
+			return fmt.Errorf("failed to write metadata of %s: %s (and failed to remove it: %s)", b.path(name), err, rmErr)
+		}
+		return fmt.Errorf("failed to write metadata of %s: %s", b.path(name), err)
+	}
+	return nil
+}
+
+func (b *DirBucket) path(name string) string {
+	return filepath.Join(b.Dir, filepath.FromSlash(name))
+}
+
+func (b *DirBucket) wrap(name string, err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%s: %w", b.path(name), ErrNotExist)
+	}
+	return fmt.Errorf("failed to read %s: %s", b.path(name), err)
+}
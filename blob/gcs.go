@@ -0,0 +1,64 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/storage/v1"
+)
+
+// GCSBucket is a bucket of Google Cloud Storage.
+type GCSBucket struct {
+	service *storage.Service
+	bucket  string
+}
+
+func NewGCSBucket(ctx context.Context, bucket string) (*GCSBucket, error) {
+	service, err := storage.NewService(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage service: %s", err)
+	}
+	return &GCSBucket{service: service, bucket: bucket}, nil
+}
+
+func (b *GCSBucket) Attrs(ctx context.Context, name string) (Attrs, error) {
+	o, err := b.service.Objects.Get(b.bucket, name).Context(ctx).Do()
+	if err != nil {
+		return Attrs{}, b.wrap(name, err)
+	}
+
+	updated, err := time.Parse(time.RFC3339, o.Updated)
+	if err != nil {
+		return Attrs{}, fmt.Errorf("failed to parse updated time of gs://%s/%s: %s", b.bucket, name, err)
+	}
+	return Attrs{Updated: updated, Metadata: o.Metadata}, nil
+}
+
+func (b *GCSBucket) NewReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	res, err := b.service.Objects.Get(b.bucket, name).Context(ctx).Download()
+	if err != nil {
+		return nil, b.wrap(name, err)
+	}
+	return res.Body, nil
+}
+
+func (b *GCSBucket) Write(ctx context.Context, name string, r io.Reader, metadata map[string]string) error {
+	_, err := b.service.Objects.Insert(b.bucket, &storage.Object{Name: name, Metadata: metadata}).Media(r).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to write gs://%s/%s: %s", b.bucket, name, err)
+	}
+	return nil
+}
+
+func (b *GCSBucket) wrap(name string, err error) error {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return fmt.Errorf("gs://%s/%s: %w", b.bucket, name, ErrNotExist)
+	}
+	return fmt.Errorf("failed to read gs://%s/%s: %s", b.bucket, name, err)
+}
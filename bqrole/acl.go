@@ -0,0 +1,84 @@
+package bqrole
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	bq "cloud.google.com/go/bigquery"
+	"google.golang.org/api/googleapi"
+)
+
+// ErrDatasetConflict is returned by DatasetBackend.SetAccess when the dataset was modified
+// since it was read, i.e. its ETag no longer matches.
+var ErrDatasetConflict = errors.New("dataset was modified concurrently")
+
+// DatasetBackend reads and writes the access list of BigQuery datasets.
+type DatasetBackend interface {
+	GetAccess(ctx context.Context, project, dataset string) (*DatasetAccess, error)
+	// SetAccess replaces the access list of the dataset if it has not changed since access.ETag.
+	SetAccess(ctx context.Context, project, dataset string, access *DatasetAccess) error
+}
+
+// DatasetAccess is the access list of a dataset with the ETag it was read at.
+type DatasetAccess struct {
+	Entries []*bq.AccessEntry
+	ETag    string
+}
+
+// BigQueryDatasetBackend is a DatasetBackend using the BigQuery API.
+type BigQueryDatasetBackend struct {
+	client *bq.Client
+}
+
+func NewBigQueryDatasetBackend(client *bq.Client) *BigQueryDatasetBackend {
+	return &BigQueryDatasetBackend{client: client}
+}
+
+func (b *BigQueryDatasetBackend) GetAccess(ctx context.Context, project, dataset string) (*DatasetAccess, error) {
+	meta, err := b.client.DatasetInProject(project, dataset).Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &DatasetAccess{Entries: meta.Access, ETag: meta.ETag}, nil
+}
+
+func (b *BigQueryDatasetBackend) SetAccess(ctx context.Context, project, dataset string, access *DatasetAccess) error {
+	update := bq.DatasetMetadataToUpdate{Access: access.Entries}
+	_, err := b.client.DatasetInProject(project, dataset).Update(ctx, update, access.ETag)
+	if isPreconditionFailed(err) {
+		return fmt.Errorf("%w: %s", ErrDatasetConflict, err)
+	}
+	return err
+}
+
+// updateAccess reads the access list of the dataset, applies mutate to it
+// and writes it back guarded by its ETag.
+// mutate returns false if there is nothing to write.
+// The whole read-modify-write is retried when the dataset is modified concurrently.
+func updateAccess(ctx context.Context, acl DatasetBackend, retry RetryPolicy, project, dataset string, mutate func(*DatasetAccess) bool) error {
+	return retry.Do(ctx, isDatasetConflict, func() error {
+		access, err := acl.GetAccess(ctx, project, dataset)
+		if err != nil {
+			return err
+		}
+
+		if !mutate(access) {
+			return nil
+		}
+
+		return acl.SetAccess(ctx, project, dataset, access)
+	})
+}
+
+func isDatasetConflict(err error) bool {
+	return errors.Is(err, ErrDatasetConflict)
+}
+
+// isPreconditionFailed reports whether err is a 412 response,
+// which BigQuery returns when the ETag of the dataset doesn't match.
+func isPreconditionFailed(err error) bool {
+	var gerr *googleapi.Error
+	return errors.As(err, &gerr) && gerr.Code == http.StatusPreconditionFailed
+}
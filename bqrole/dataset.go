@@ -1,13 +1,8 @@
 package bqrole
 
 import (
-	"bufio"
 	"context"
-	"errors"
 	"fmt"
-	"os"
-	"os/exec"
-	"strings"
 
 	bq "cloud.google.com/go/bigquery"
 	"github.com/rs/zerolog/log"
@@ -26,203 +21,232 @@ func DatasetRole(role string) (bq.AccessRole, error) {
 	return "", fmt.Errorf("failed to parse %s", role)
 }
 
-func PermitDataset(role bq.AccessRole, project string, users, datasets []string, yes bool) error {
-	ctx := context.Background()
-	client, err := bq.NewClient(ctx, project)
-	if err != nil {
-		return errors.New("failed to create bigquery Client")
-	}
-	defer client.Close()
-
+func PermitDataset(ctx context.Context, iam IAMBackend, acl DatasetBackend, role bq.AccessRole, project string, members []Member, datasets []string, opts Options) error {
 	fmt.Printf("PERMIT following roles\n")
 	fmt.Printf("project_id: %s\n", project)
 	fmt.Printf("role:       %s\n", role)
 	fmt.Printf("datasets:   %s\n", datasets)
-	fmt.Printf("users:      %s\n", users)
-
-	if !yes {
-		fmt.Printf("Are you sure? [y/n]")
-
-		reader := bufio.NewReader(os.Stdin)
-		res, err := reader.ReadString('\n')
+	fmt.Printf("users:      %s\n", members)
+	opts.printExpires()
 
-		if err != nil || strings.TrimSpace(res) != "y" {
-			fmt.Println("Abort.")
-			return nil
-		}
+	if !opts.Yes && !confirm("Are you sure? [y/n]") {
+		fmt.Println("Abort.")
+		return nil
 	}
 
-	policy, err := FetchCurrentPolicy(project)
-	if err != nil {
-		return fmt.Errorf("failed to fetch current policy: %s", err)
-	}
+	return permitDataset(ctx, iam, acl, role, project, members, datasets, opts)
+}
 
+func permitDataset(ctx context.Context, iam IAMBackend, acl DatasetBackend, role bq.AccessRole, project string, members []Member, datasets []string, opts Options) error {
 	// grant roles/bigquery.jobUser and roles/bigquery.user if needed
-	for _, user := range users {
-		err = grantBQRole(project, user, "roles/bigquery.jobUser", policy)
-		if err != nil {
-			return err
-		}
-
-		err = grantBQRole(project, user, "roles/bigquery.user", policy)
-		if err != nil {
-			return err
-		}
+	err := grantProjectRoles(ctx, iam, project, members, []string{"roles/bigquery.jobUser", "roles/bigquery.user"}, opts)
+	if err != nil {
+		return err
 	}
 
 	// grant permissions for each datasets
+	var failed []string
 	for _, dataset := range datasets {
-		for _, user := range users {
-			err := grantDatasetPermission(ctx, client, role, dataset, user, bq.UserEmailEntity)
-			if err != nil {
-				// try as group account
-				log.Warn().Msg("failed to permit using bq.UserEmailEntity, try bq.GroupEmailEnity")
-				err = grantDatasetPermission(ctx, client, role, dataset, user, bq.GroupEmailEntity)
-				if err != nil {
-					return err
-				}
-			}
-			fmt.Printf("Permit %s to %s access as %s\n", user, dataset, role)
+		report := grantDatasetAccess(ctx, acl, role, project, dataset, members, opts)
+		if !opts.quiet {
+			report.Print()
+		}
+		if opts.Ledger != nil {
+			recordExpiry(opts.Ledger, report, project, members, opts.Expires)
+		}
+		if len(report.Failed) > 0 {
+			failed = append(failed, dataset)
 		}
 	}
 
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to permit some users to datasets: %s", failed)
+	}
 	return nil
 }
 
-func RevokeDataset(role bq.AccessRole, project string, users, datasets []string, yes bool) error {
-	ctx := context.Background()
-	client, err := bq.NewClient(ctx, project)
-	if err != nil {
-		return errors.New("failed to create bigquery Client")
-	}
-	defer client.Close()
-
+func RevokeDataset(ctx context.Context, acl DatasetBackend, role bq.AccessRole, project string, members []Member, datasets []string, opts Options) error {
 	fmt.Printf("REVOKE following roles\n")
 	fmt.Printf("project_id: %s\n", project)
 	fmt.Printf("role:       %s\n", role)
 	fmt.Printf("datasets:   %s\n", datasets)
-	fmt.Printf("users:      %s\n", users)
-
-	if !yes {
-		fmt.Printf("Are you sure? [y/n]")
+	fmt.Printf("users:      %s\n", members)
 
-		reader := bufio.NewReader(os.Stdin)
-		res, err := reader.ReadString('\n')
-
-		if err != nil || strings.TrimSpace(res) != "y" {
-			fmt.Println("Abort.")
-			return nil
-		}
+	if !opts.Yes && !confirm("Are you sure? [y/n]") {
+		fmt.Println("Abort.")
+		return nil
 	}
 
+	return revokeDataset(ctx, acl, role, project, members, datasets, opts)
+}
+
+func revokeDataset(ctx context.Context, acl DatasetBackend, role bq.AccessRole, project string, members []Member, datasets []string, opts Options) error {
 	// revoke permissions for each datasets
 	for _, dataset := range datasets {
-		for _, user := range users {
-			err := revokeDatasetPermission(ctx, client, role, dataset, user, bq.UserEmailEntity)
+		for _, m := range members {
+			revoked, err := revokeDatasetPermission(ctx, acl, opts.Retry, role, project, dataset, m)
 			if err != nil {
+				return err
+			}
+			if !revoked && m.canBeGroup(opts) {
 				// try as group account
-				log.Warn().Msg("failed to revoke using bq.UserEmailEntity, try bq.GroupEmailEnity")
-				err = revokeDatasetPermission(ctx, client, role, dataset, user, bq.GroupEmailEntity)
+				log.Warn().Msgf("no access entry found for user %s, try group", m.ID)
+				revoked, err = revokeDatasetPermission(ctx, acl, opts.Retry, role, project, dataset, m.asGroup())
 				if err != nil {
 					return err
 				}
 			}
-			fmt.Printf("Revoked %s's permission of %s access as %s\n", user, dataset, role)
+			if !revoked {
+				log.Info().Msgf("%s doesn't have a role: %s, dataset: %s. skipped.", m.ID, role, dataset)
+				continue
+			}
+			opts.printf("Revoked %s's permission of %s access as %s\n", m.ID, dataset, role)
 		}
 	}
 
 	return nil
 }
 
-// grantBQRole grants user roles/bigquery permission
-func grantBQRole(project, user, role string, policy *ProjectPolicy) error {
-	if hasBQRole(policy, user, role) {
-		log.Info().Msgf("%s already have %s\n", user, role)
-		return nil
-	}
+// DatasetReport is the result of granting a role on a dataset to members.
+type DatasetReport struct {
+	Dataset  string
+	Role     bq.AccessRole
+	Added    []string // members granted by this run
+	Existing []string // members who already had the role
+	Failed   []string // members who could not be granted
+	Err      error    // the reason of the failure
+}
 
-	var member string
-	if isServiceAccount(user) {
-		member = "serviceAccount:" + user
-	} else {
-		member = "user:" + user
+func (r *DatasetReport) Print() {
+	for _, user := range r.Added {
+		fmt.Printf("Permit %s to %s access as %s\n", user, r.Dataset, r.Role)
 	}
-
-	cmd := exec.Command("gcloud", "projects", "add-iam-policy-binding", project, "--member", member, "--role", role, "--condition=None")
-	out, err := cmd.CombinedOutput()
-	if !strings.Contains(string(out), "INVALID_ARGUMENT") {
-		if err != nil {
-			fmt.Fprintln(os.Stderr, string(out))
-			return fmt.Errorf("failed to update policy bindings to grant %s %s: %s", user, role, err)
-		}
-		return nil
+	for _, user := range r.Existing {
+		fmt.Printf("%s already has %s access as %s\n", user, r.Dataset, r.Role)
 	}
-
-	// try to bind to "group" account
-	log.Warn().Msg("failed to permit as user account, try group account")
-	member = "group:" + user
-	cmd = exec.Command("gcloud", "projects", "add-iam-policy-binding", project, "--member", member, "--role", role, "--condition=None")
-	cmd.Stderr = os.Stderr
-	err = cmd.Run()
-	if err != nil {
-		return fmt.Errorf("failed to update policy bindings to grant %s %s: %s", user, role, err)
+	for _, user := range r.Failed {
+		fmt.Printf("Failed to permit %s to %s access as %s: %s\n", user, r.Dataset, r.Role, r.Err)
 	}
-
-	return nil
 }
 
-func grantDatasetPermission(ctx context.Context, client *bq.Client, role bq.AccessRole, dataset string, user string, entityType bq.EntityType) error {
-	ds := client.Dataset(dataset)
-	meta, err := ds.Metadata(ctx)
-	if err != nil {
-		return err
+// grantDatasetAccess grants role on the dataset to all the members with a single access list update.
+func grantDatasetAccess(ctx context.Context, acl DatasetBackend, role bq.AccessRole, project, dataset string, members []Member, opts Options) *DatasetReport {
+	report := &DatasetReport{Dataset: dataset, Role: role}
+
+	var pending []Member
+	err := updateAccess(ctx, acl, opts.Retry, project, dataset, func(access *DatasetAccess) bool {
+		report.Existing, pending = nil, nil
+		for _, m := range members {
+			if current, ok := datasetRoleOf(access.Entries, m); ok && coversRole(current, role) {
+				log.Info().Msgf("%s already has a role: %s, dataset: %s. skipped.", m.ID, current, dataset)
+				report.Existing = append(report.Existing, m.ID)
+				continue
+			}
+			access.Entries = append(access.Entries, &bq.AccessEntry{
+				Role:       role,
+				EntityType: m.EntityType(),
+				Entity:     m.ID,
+			})
+			pending = append(pending, m)
+		}
+		return len(pending) > 0
+	})
+	if err == nil {
+		report.Added = memberIDs(pending)
+		return report
+	}
+	if !isInvalidArgument(err) || !anyCanBeGroup(pending, opts) {
+		if pending == nil { // failed to read the access list
+			pending = members
+		}
+		report.Failed, report.Err = memberIDs(pending), err
+		return report
+	}
+
+	// some of the members may be group accounts, so grant them one by one
+	log.Warn().Msgf("failed to update access of %s at once, try granting members one by one", dataset)
+	for _, m := range pending {
+		err := grantDatasetPermission(ctx, acl, opts.Retry, role, project, dataset, m)
+		if err != nil && m.canBeGroup(opts) {
+			// try as group account
+			log.Warn().Msgf("failed to permit %s as user, try group", m.ID)
+			err = grantDatasetPermission(ctx, acl, opts.Retry, role, project, dataset, m.asGroup())
+		}
+		if err != nil {
+			report.Failed, report.Err = append(report.Failed, m.ID), err
+			continue
+		}
+		report.Added = append(report.Added, m.ID)
 	}
+	return report
+}
 
-	update := bq.DatasetMetadataToUpdate{
-		Access: append(meta.Access, &bq.AccessEntry{
+func grantDatasetPermission(ctx context.Context, acl DatasetBackend, retry RetryPolicy, role bq.AccessRole, project, dataset string, m Member) error {
+	return updateAccess(ctx, acl, retry, project, dataset, func(access *DatasetAccess) bool {
+		if current, ok := datasetRoleOf(access.Entries, m); ok && coversRole(current, role) {
+			return false
+		}
+		access.Entries = append(access.Entries, &bq.AccessEntry{
 			Role:       role,
-			EntityType: entityType,
-			Entity:     user,
-		}),
-	}
-
-	if _, err := ds.Update(ctx, update, meta.ETag); err != nil {
-		return err
-	}
-	return nil
+			EntityType: m.EntityType(),
+			Entity:     m.ID,
+		})
+		return true
+	})
 }
 
-func revokeDatasetPermission(ctx context.Context, client *bq.Client, role bq.AccessRole, dataset string, user string, entityType bq.EntityType) error {
-	ds := client.Dataset(dataset)
-	meta, err := ds.Metadata(ctx)
+// revokeDatasetPermission removes the access entry of m from the dataset.
+// It returns false if the dataset has no such entry.
+func revokeDatasetPermission(ctx context.Context, acl DatasetBackend, retry RetryPolicy, role bq.AccessRole, project, dataset string, m Member) (bool, error) {
+	var revoked bool
+	err := updateAccess(ctx, acl, retry, project, dataset, func(access *DatasetAccess) bool {
+		var entries []*bq.AccessEntry
+		for _, e := range access.Entries {
+			if e.Role == role && m.matchesEntry(e) {
+				continue // skipping the target entity
+			}
+			entries = append(entries, e)
+		}
+
+		revoked = len(entries) < len(access.Entries)
+		access.Entries = entries
+		return revoked
+	})
 	if err != nil {
-		return err
+		return false, err
 	}
+	return revoked, nil
+}
 
-	var accesses []*bq.AccessEntry
-	for _, access := range meta.Access {
-		if access.EntityType == entityType && access.Entity == user && access.Role == role {
-			continue // skipping the target entity
+// datasetRoleOf returns the highest role granted to m directly on the dataset.
+func datasetRoleOf(entries []*bq.AccessEntry, m Member) (bq.AccessRole, bool) {
+	var role bq.AccessRole
+	found := false
+	for _, e := range entries {
+		if !m.matchesEntry(e) {
+			continue
+		}
+		if !found || roleRank(e.Role) > roleRank(role) {
+			role, found = e.Role, true
 		}
-		accesses = append(accesses, access)
 	}
+	return role, found
+}
 
-	update := bq.DatasetMetadataToUpdate{Access: accesses}
-	if _, err := ds.Update(ctx, update, meta.ETag); err != nil {
-		return err
-	}
-	return nil
+// coversRole reports whether current grants everything role does, e.g. OWNER covers READER.
+func coversRole(current, role bq.AccessRole) bool {
+	return roleRank(current) >= roleRank(role)
 }
 
-func hasBQRole(p *ProjectPolicy, user string, role string) bool {
-	for _, b := range p.Bindings {
-		if b.Role == role {
-			for _, m := range b.Members {
-				if strings.HasSuffix(m, user) { // format of m is (user|serviceAccount):[user-email]
-					return true
-				}
-			}
-		}
-	}
-	return false
+// roleRank orders dataset roles, accepting both basic roles and their IAM equivalents.
+func roleRank(role bq.AccessRole) int {
+	switch role {
+	case bq.ReaderRole, "roles/bigquery.dataViewer":
+		return 1
+	case bq.WriterRole, "roles/bigquery.dataEditor":
+		return 2
+	case bq.OwnerRole, "roles/bigquery.dataOwner":
+		return 3
+	}
+	return 0
 }
@@ -0,0 +1,335 @@
+package bqrole
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	bq "cloud.google.com/go/bigquery"
+)
+
+func TestPermitDataset(t *testing.T) {
+	owner := &bq.AccessEntry{Role: bq.OwnerRole, EntityType: bq.UserEmailEntity, Entity: "owner@email.com"}
+	reader := &bq.AccessEntry{Role: bq.ReaderRole, EntityType: bq.UserEmailEntity, Entity: "reader@email.com"}
+
+	cases := []struct {
+		name  string
+		users []string
+		want  []*bq.AccessEntry
+	}{
+		{
+			name:  "user",
+			users: []string{"user1@email.com"},
+			want: []*bq.AccessEntry{
+				{Role: bq.ReaderRole, EntityType: bq.UserEmailEntity, Entity: "user1@email.com"},
+			},
+		},
+		{
+			name:  "group",
+			users: []string{"group@email.com"},
+			want: []*bq.AccessEntry{
+				{Role: bq.ReaderRole, EntityType: bq.GroupEmailEntity, Entity: "group@email.com"},
+			},
+		},
+		{
+			name:  "user and group",
+			users: []string{"user1@email.com", "group@email.com"},
+			want: []*bq.AccessEntry{
+				{Role: bq.ReaderRole, EntityType: bq.UserEmailEntity, Entity: "user1@email.com"},
+				{Role: bq.ReaderRole, EntityType: bq.GroupEmailEntity, Entity: "group@email.com"},
+			},
+		},
+		{
+			name:  "duplicate grant",
+			users: []string{"reader@email.com"},
+			want: []*bq.AccessEntry{
+				{Role: bq.ReaderRole, EntityType: bq.UserEmailEntity, Entity: "reader@email.com"},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			iam := NewFakeIAMBackend(map[string]*ProjectPolicy{"bq-project": {}})
+			acl := NewFakeDatasetBackend(map[string][]*bq.AccessEntry{
+				"bq-project.dataset1": {owner, reader},
+			}, "group@email.com")
+
+			if err := PermitDataset(ctx, iam, acl, bq.ReaderRole, "bq-project", mustParseMembers(t, c.users), []string{"dataset1"}, testOptions); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			access, _ := acl.GetAccess(ctx, "bq-project", "dataset1")
+			for _, w := range c.want {
+				if !containsEntry(access.Entries, w) {
+					t.Errorf("access entry %v not found in %v", w, access.Entries)
+				}
+			}
+			if !containsEntry(access.Entries, owner) {
+				t.Errorf("existing access entry %v is lost", owner)
+			}
+		})
+	}
+}
+
+func TestGrantDatasetAccess(t *testing.T) {
+	reader := &bq.AccessEntry{Role: bq.ReaderRole, EntityType: bq.UserEmailEntity, Entity: "reader@email.com"}
+	owner := &bq.AccessEntry{Role: bq.OwnerRole, EntityType: bq.UserEmailEntity, Entity: "owner@email.com"}
+	group := &bq.AccessEntry{Role: bq.WriterRole, EntityType: bq.GroupEmailEntity, Entity: "writers@email.com"}
+	member := &bq.AccessEntry{Role: "roles/bigquery.dataViewer", EntityType: bq.IAMMemberEntity, Entity: "user:member@email.com"}
+
+	cases := []struct {
+		name     string
+		dataset  string
+		users    []string
+		want     DatasetReport
+		wantSets int
+	}{
+		{
+			name:     "added and existing",
+			dataset:  "dataset1",
+			users:    []string{"user1@email.com", "user2@email.com", "reader@email.com"},
+			want:     DatasetReport{Dataset: "dataset1", Role: bq.ReaderRole, Added: []string{"user1@email.com", "user2@email.com"}, Existing: []string{"reader@email.com"}},
+			wantSets: 1,
+		},
+		{
+			name:     "all existing",
+			dataset:  "dataset1",
+			users:    []string{"reader@email.com"},
+			want:     DatasetReport{Dataset: "dataset1", Role: bq.ReaderRole, Existing: []string{"reader@email.com"}},
+			wantSets: 0,
+		},
+		{
+			name:     "higher role",
+			dataset:  "dataset1",
+			users:    []string{"owner@email.com", "writers@email.com"},
+			want:     DatasetReport{Dataset: "dataset1", Role: bq.ReaderRole, Existing: []string{"owner@email.com", "writers@email.com"}},
+			wantSets: 0,
+		},
+		{
+			name:     "iam member",
+			dataset:  "dataset1",
+			users:    []string{"member@email.com"},
+			want:     DatasetReport{Dataset: "dataset1", Role: bq.ReaderRole, Existing: []string{"member@email.com"}},
+			wantSets: 0,
+		},
+		{
+			name:     "group fallback",
+			dataset:  "dataset1",
+			users:    []string{"user1@email.com", "group@email.com"},
+			want:     DatasetReport{Dataset: "dataset1", Role: bq.ReaderRole, Added: []string{"user1@email.com", "group@email.com"}},
+			wantSets: 2,
+		},
+		{
+			name:     "missing dataset",
+			dataset:  "dataset2",
+			users:    []string{"user1@email.com"},
+			want:     DatasetReport{Dataset: "dataset2", Role: bq.ReaderRole, Failed: []string{"user1@email.com"}},
+			wantSets: 0,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			acl := NewFakeDatasetBackend(map[string][]*bq.AccessEntry{
+				"bq-project.dataset1": {reader, owner, group, member},
+			}, "group@email.com")
+
+			got := grantDatasetAccess(ctx, acl, bq.ReaderRole, "bq-project", c.dataset, mustParseMembers(t, c.users), testOptions)
+			if (got.Err != nil) != (len(c.want.Failed) > 0) {
+				t.Errorf("unexpected error: %v", got.Err)
+			}
+			got.Err = nil
+			if !reflect.DeepEqual(*got, c.want) {
+				t.Errorf("got: %+v, want: %+v", *got, c.want)
+			}
+			if got := acl.SetAccessCalls(); got != c.wantSets {
+				t.Errorf("update calls got: %d, want: %d", got, c.wantSets)
+			}
+		})
+	}
+}
+
+// racyDatasetBackend modifies the access list behind the caller's back before the first write.
+type racyDatasetBackend struct {
+	*FakeDatasetBackend
+	raced bool
+}
+
+func (r *racyDatasetBackend) SetAccess(ctx context.Context, project, dataset string, access *DatasetAccess) error {
+	if !r.raced {
+		r.raced = true
+		err := grantDatasetPermission(ctx, r.FakeDatasetBackend, testOptions.Retry, bq.WriterRole, project, dataset, Member{Type: UserMember, ID: "other@email.com"})
+		if err != nil {
+			return err
+		}
+	}
+	return r.FakeDatasetBackend.SetAccess(ctx, project, dataset, access)
+}
+
+func TestGrantDatasetAccessConflict(t *testing.T) {
+	ctx := context.Background()
+	acl := &racyDatasetBackend{FakeDatasetBackend: NewFakeDatasetBackend(map[string][]*bq.AccessEntry{"bq-project.dataset1": nil})}
+
+	report := grantDatasetAccess(ctx, acl, bq.ReaderRole, "bq-project", "dataset1", mustParseMembers(t, []string{"user1@email.com"}), testOptions)
+	if report.Err != nil {
+		t.Fatalf("unexpected error: %v", report.Err)
+	}
+
+	access, _ := acl.GetAccess(ctx, "bq-project", "dataset1")
+	want := []*bq.AccessEntry{
+		{Role: bq.WriterRole, EntityType: bq.UserEmailEntity, Entity: "other@email.com"},
+		{Role: bq.ReaderRole, EntityType: bq.UserEmailEntity, Entity: "user1@email.com"},
+	}
+	if !reflect.DeepEqual(access.Entries, want) {
+		t.Errorf("got: %v, want: %v", access.Entries, want)
+	}
+}
+
+func TestGrantDatasetAccessLowerRole(t *testing.T) {
+	ctx := context.Background()
+	reader := &bq.AccessEntry{Role: bq.ReaderRole, EntityType: bq.UserEmailEntity, Entity: "user1@email.com"}
+	acl := NewFakeDatasetBackend(map[string][]*bq.AccessEntry{"bq-project.dataset1": {reader}})
+
+	for i := 0; i < 2; i++ { // the second run must be a no-op
+		report := grantDatasetAccess(ctx, acl, bq.WriterRole, "bq-project", "dataset1", mustParseMembers(t, []string{"user1@email.com"}), testOptions)
+		if report.Err != nil {
+			t.Fatalf("unexpected error: %v", report.Err)
+		}
+	}
+
+	access, _ := acl.GetAccess(ctx, "bq-project", "dataset1")
+	want := []*bq.AccessEntry{reader, {Role: bq.WriterRole, EntityType: bq.UserEmailEntity, Entity: "user1@email.com"}}
+	if !reflect.DeepEqual(access.Entries, want) {
+		t.Errorf("got: %v, want: %v", access.Entries, want)
+	}
+}
+
+func TestRevokeDataset(t *testing.T) {
+	user := &bq.AccessEntry{Role: bq.ReaderRole, EntityType: bq.UserEmailEntity, Entity: "user1@email.com"}
+	group := &bq.AccessEntry{Role: bq.ReaderRole, EntityType: bq.GroupEmailEntity, Entity: "group@email.com"}
+	iamMember := &bq.AccessEntry{Role: bq.ReaderRole, EntityType: bq.IAMMemberEntity, Entity: "user:member@email.com"}
+
+	cases := []struct {
+		name  string
+		users []string
+		want  []*bq.AccessEntry
+	}{
+		{
+			name:  "user",
+			users: []string{"user1@email.com"},
+			want:  []*bq.AccessEntry{group, iamMember},
+		},
+		{
+			name:  "guessed member stored as group",
+			users: []string{"group@email.com"},
+			want:  []*bq.AccessEntry{user, iamMember},
+		},
+		{
+			name:  "explicit group",
+			users: []string{"group:group@email.com"},
+			want:  []*bq.AccessEntry{user, iamMember},
+		},
+		{
+			name:  "guessed member stored as iamMember",
+			users: []string{"member@email.com"},
+			want:  []*bq.AccessEntry{user, group},
+		},
+		{
+			name:  "explicit type doesn't match other types",
+			users: []string{"user:group@email.com"},
+			want:  []*bq.AccessEntry{user, group, iamMember},
+		},
+		{
+			name:  "missing entry",
+			users: []string{"user2@email.com"},
+			want:  []*bq.AccessEntry{user, group, iamMember},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			acl := NewFakeDatasetBackend(map[string][]*bq.AccessEntry{
+				"bq-project.dataset1": {user, group, iamMember},
+			}, "group@email.com")
+
+			if err := RevokeDataset(ctx, acl, bq.ReaderRole, "bq-project", mustParseMembers(t, c.users), []string{"dataset1"}, testOptions); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			access, _ := acl.GetAccess(ctx, "bq-project", "dataset1")
+			if !reflect.DeepEqual(access.Entries, c.want) {
+				t.Errorf("got: %v, want: %v", access.Entries, c.want)
+			}
+		})
+	}
+}
+
+func TestPermitDatasetMemberType(t *testing.T) {
+	opts := testOptions
+	opts.DetectMemberType = false
+
+	cases := []struct {
+		name    string
+		users   []string
+		want    []*bq.AccessEntry
+		wantErr bool
+	}{
+		{
+			name:  "explicit group",
+			users: []string{"group:group@email.com"},
+			want:  []*bq.AccessEntry{{Role: bq.ReaderRole, EntityType: bq.GroupEmailEntity, Entity: "group@email.com"}},
+		},
+		{
+			name:  "domain",
+			users: []string{"domain:email.com"},
+			want:  []*bq.AccessEntry{{Role: bq.ReaderRole, EntityType: bq.DomainEntity, Entity: "email.com"}},
+		},
+		{
+			name:    "group without detection",
+			users:   []string{"group@email.com"},
+			want:    nil,
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			iam := NewFakeIAMBackend(map[string]*ProjectPolicy{"bq-project": {}})
+			acl := NewFakeDatasetBackend(map[string][]*bq.AccessEntry{"bq-project.dataset1": nil}, "group@email.com")
+
+			err := PermitDataset(ctx, iam, acl, bq.ReaderRole, "bq-project", mustParseMembers(t, c.users), []string{"dataset1"}, opts)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			access, _ := acl.GetAccess(ctx, "bq-project", "dataset1")
+			if !reflect.DeepEqual(access.Entries, c.want) {
+				t.Errorf("got: %v, want: %v", access.Entries, c.want)
+			}
+		})
+	}
+}
+
+func TestPermitDatasetNotFound(t *testing.T) {
+	ctx := context.Background()
+	iam := NewFakeIAMBackend(map[string]*ProjectPolicy{"bq-project": {}})
+	acl := NewFakeDatasetBackend(map[string][]*bq.AccessEntry{})
+
+	if err := PermitDataset(ctx, iam, acl, bq.ReaderRole, "bq-project", mustParseMembers(t, []string{"user1@email.com"}), []string{"dataset1"}, testOptions); err == nil {
+		t.Error("expected an error for missing dataset")
+	}
+}
+
+func containsEntry(entries []*bq.AccessEntry, want *bq.AccessEntry) bool {
+	for _, e := range entries {
+		if reflect.DeepEqual(e, want) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package bqrole
+
+import (
+	"fmt"
+
+	bq "cloud.google.com/go/bigquery"
+)
+
+var entityTypeNames = map[bq.EntityType]string{
+	bq.DomainEntity:       "domain",
+	bq.GroupEmailEntity:   "group",
+	bq.UserEmailEntity:    "user",
+	bq.SpecialGroupEntity: "specialGroup",
+	bq.ViewEntity:         "view",
+	bq.IAMMemberEntity:    "iamMember",
+	bq.RoutineEntity:      "routine",
+	bq.DatasetEntity:      "dataset",
+}
+
+// EntityTypeName returns the name of the entity type of dataset access entries, like "user" or "view".
+func EntityTypeName(t bq.EntityType) string {
+	if name, ok := entityTypeNames[t]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ParseEntityType is the inverse of EntityTypeName.
+func ParseEntityType(name string) (bq.EntityType, error) {
+	for t, n := range entityTypeNames {
+		if n == name {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown entity type: %s", name)
+}
+
+// EntityName returns what the access entry grants access to.
+// It's the Entity for principals, or the reference like "project.dataset.view" for authorized views, routines and datasets.
+func EntityName(e *bq.AccessEntry) string {
+	switch {
+	case e.EntityType == bq.ViewEntity && e.View != nil:
+		return fmt.Sprintf("%s.%s.%s", e.View.ProjectID, e.View.DatasetID, e.View.TableID)
+	case e.EntityType == bq.RoutineEntity && e.Routine != nil:
+		return fmt.Sprintf("%s.%s.%s", e.Routine.ProjectID, e.Routine.DatasetID, e.Routine.RoutineID)
+	case e.EntityType == bq.DatasetEntity && e.Dataset != nil && e.Dataset.Dataset != nil:
+		return fmt.Sprintf("%s.%s", e.Dataset.Dataset.ProjectID, e.Dataset.Dataset.DatasetID)
+	}
+	return e.Entity
+}
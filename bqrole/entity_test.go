@@ -0,0 +1,65 @@
+package bqrole
+
+import (
+	"testing"
+
+	bq "cloud.google.com/go/bigquery"
+)
+
+func TestEntityName(t *testing.T) {
+	cases := []struct {
+		name  string
+		entry *bq.AccessEntry
+		want  string
+	}{
+		{
+			name:  "user",
+			entry: &bq.AccessEntry{EntityType: bq.UserEmailEntity, Entity: "user@email.com"},
+			want:  "user@email.com",
+		},
+		{
+			name:  "special group",
+			entry: &bq.AccessEntry{EntityType: bq.SpecialGroupEntity, Entity: "projectReaders"},
+			want:  "projectReaders",
+		},
+		{
+			name:  "view",
+			entry: &bq.AccessEntry{EntityType: bq.ViewEntity, View: &bq.Table{ProjectID: "p", DatasetID: "d", TableID: "v"}},
+			want:  "p.d.v",
+		},
+		{
+			name:  "routine",
+			entry: &bq.AccessEntry{EntityType: bq.RoutineEntity, Routine: &bq.Routine{ProjectID: "p", DatasetID: "d", RoutineID: "r"}},
+			want:  "p.d.r",
+		},
+		{
+			name:  "dataset",
+			entry: &bq.AccessEntry{EntityType: bq.DatasetEntity, Dataset: &bq.DatasetAccessEntry{Dataset: &bq.Dataset{ProjectID: "p", DatasetID: "d"}}},
+			want:  "p.d",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := EntityName(c.entry); got != c.want {
+				t.Errorf("got: %s, want: %s", got, c.want)
+			}
+		})
+	}
+}
+
+func TestParseEntityType(t *testing.T) {
+	for typ, name := range entityTypeNames {
+		got, err := ParseEntityType(name)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != typ || EntityTypeName(got) != name {
+			t.Errorf("got: %v, want: %v", got, typ)
+		}
+	}
+
+	if _, err := ParseEntityType("team"); err == nil {
+		t.Error("expected error for unknown entity type")
+	}
+}
@@ -0,0 +1,287 @@
+package bqrole
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"github.com/BurntSushi/toml"
+	"github.com/rs/zerolog/log"
+
+	"github.com/hirosassa/bqiam/atomicfile"
+)
+
+// ExpiryConditionTitle is the title of the IAM conditions that bqiam binds time-bound project roles with.
+const ExpiryConditionTitle = "bqiam-expiry"
+
+// ParseExpires returns the deadline of a grant for d after now.
+// d is a number of days (7d) or weeks (2w), or a Go duration like 12h.
+func ParseExpires(d string, now time.Time) (time.Time, error) {
+	var unit time.Duration
+	switch {
+	case strings.HasSuffix(d, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(d, "w"):
+		unit = 7 * 24 * time.Hour
+	default:
+		duration, err := time.ParseDuration(d)
+		if err != nil || duration <= 0 {
+			return time.Time{}, fmt.Errorf("invalid expiry %q (must be like 7d, 2w or 12h)", d)
+		}
+		return now.Add(duration), nil
+	}
+
+	n, err := strconv.Atoi(d[:len(d)-1])
+	if err != nil || n <= 0 {
+		return time.Time{}, fmt.Errorf("invalid expiry %q (must be like 7d, 2w or 12h)", d)
+	}
+	return now.Add(time.Duration(n) * unit), nil
+}
+
+// expiryCondition returns the IAM condition granting a role until expires.
+func expiryCondition(expires time.Time) *Condition {
+	deadline := expires.UTC().Format(time.RFC3339)
+	return &Condition{
+		Title:       ExpiryConditionTitle,
+		Description: "granted by bqiam until " + deadline,
+		Expression:  fmt.Sprintf("request.time < timestamp(%q)", deadline),
+	}
+}
+
+// conditionExpiry returns the deadline of the condition made by expiryCondition.
+func conditionExpiry(c *Condition) (time.Time, bool) {
+	if !isExpiryCondition(c) {
+		return time.Time{}, false
+	}
+	_, rest, ok := strings.Cut(c.Expression, `timestamp("`)
+	if !ok {
+		return time.Time{}, false
+	}
+	deadline, _, ok := strings.Cut(rest, `")`)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, deadline)
+	return t, err == nil
+}
+
+// isExpiryCondition reports whether the condition is made by expiryCondition.
+func isExpiryCondition(c *Condition) bool {
+	return c != nil && c.Title == ExpiryConditionTitle
+}
+
+// Ledger records the time-bound dataset grants, which dataset access entries can't expire by themselves.
+type Ledger struct {
+	Grants []ExpiringGrant `toml:"Grants"`
+}
+
+// ExpiringGrant is a dataset role granted to a member until Expires.
+type ExpiringGrant struct {
+	Project string        `toml:"Project"`
+	Dataset string        `toml:"Dataset"`
+	Role    bq.AccessRole `toml:"Role"`
+	Member  string        `toml:"Member"` // as given to permit, like user:user1@email.com or a bare email
+	Expires time.Time     `toml:"Expires"`
+}
+
+func (g ExpiringGrant) String() string {
+	return fmt.Sprintf("%s.%s %s %s (expires at %s)", g.Project, g.Dataset, g.Role, g.Member, g.Expires.Local().Format(time.DateTime))
+}
+
+// LoadLedger reads the ledger file, which is empty if the file doesn't exist yet.
+func LoadLedger(file string) (*Ledger, error) {
+	var l Ledger
+	if _, err := toml.DecodeFile(file, &l); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to load expiry ledger file: %s", err)
+	}
+	return &l, nil
+}
+
+// Save stores the ledger to the file atomically.
+func (l *Ledger) Save(file string) error {
+	err := atomicfile.Write(file, func(w io.Writer) error {
+		return toml.NewEncoder(w).Encode(l)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save expiry ledger to the file. err: %s", err)
+	}
+	return nil
+}
+
+// SaveLocked is Save for callers holding atomicfile.Lock(file) since LoadLedger,
+// so that concurrent runs don't overwrite each other's changes.
+func (l *Ledger) SaveLocked(file string) error {
+	err := atomicfile.WriteLocked(file, func(w io.Writer) error {
+		return toml.NewEncoder(w).Encode(l)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save expiry ledger to the file. err: %s", err)
+	}
+	return nil
+}
+
+// record adds the grant, or extends the deadline of the same grant. A later deadline is never shortened.
+func (l *Ledger) record(g ExpiringGrant) {
+	if !l.extend(g) {
+		l.Grants = append(l.Grants, g)
+	}
+}
+
+// extend extends the deadline of the same grant if it's recorded, and reports whether it's recorded.
+// A later deadline is never shortened, and grants not recorded are permanent, and not made time-bound.
+func (l *Ledger) extend(g ExpiringGrant) bool {
+	i := l.index(g)
+	if i < 0 {
+		return false
+	}
+	if g.Expires.After(l.Grants[i].Expires) {
+		l.Grants[i].Expires = g.Expires
+	}
+	return true
+}
+
+// forget removes the records of the grant, which is permanent now, given to the member with the same email of any type.
+func (l *Ledger) forget(g ExpiringGrant) {
+	id := ledgerMemberID(g.Member)
+	var grants []ExpiringGrant
+	for _, r := range l.Grants {
+		if r.Project == g.Project && r.Dataset == g.Dataset && r.Role == g.Role && ledgerMemberID(r.Member) == id {
+			log.Info().Msgf("%s is permanent now, and removed from the expiry ledger", r)
+			continue
+		}
+		grants = append(grants, r)
+	}
+	l.Grants = grants
+}
+
+func ledgerMemberID(member string) string {
+	if m, err := ParseMember(member); err == nil {
+		return m.ID
+	}
+	return member
+}
+
+func (l *Ledger) index(g ExpiringGrant) int {
+	for i, r := range l.Grants {
+		if r.Project == g.Project && r.Dataset == g.Dataset && r.Role == g.Role && r.Member == g.Member {
+			return i
+		}
+	}
+	return -1
+}
+
+// Due returns the grants expired at now.
+func (l *Ledger) Due(now time.Time) []ExpiringGrant {
+	var due []ExpiringGrant
+	for _, g := range l.Grants {
+		if !g.Expires.After(now) {
+			due = append(due, g)
+		}
+	}
+	return due
+}
+
+func (l *Ledger) remove(g ExpiringGrant) {
+	if i := l.index(g); i >= 0 {
+		l.Grants = append(l.Grants[:i:i], l.Grants[i+1:]...)
+	}
+}
+
+// recordExpiry records the dataset grants made by permit with Options.Expires in the ledger.
+// If expires is zero, the grants are permanent, and removed from the ledger instead.
+func recordExpiry(ledger *Ledger, report *DatasetReport, project string, members []Member, expires time.Time) {
+	for _, m := range members {
+		g := ExpiringGrant{Project: project, Dataset: report.Dataset, Role: report.Role, Member: ledgerMember(m), Expires: expires}
+		switch {
+		case expires.IsZero():
+			if slices.Contains(report.Added, m.ID) || slices.Contains(report.Existing, m.ID) {
+				ledger.forget(g)
+			}
+		case slices.Contains(report.Added, m.ID):
+			ledger.record(g)
+		case slices.Contains(report.Existing, m.ID):
+			if ledger.extend(g) {
+				log.Info().Msgf("extended the expiry of %s", g)
+			}
+		}
+	}
+}
+
+// ledgerMember returns m as given to permit, so that a guessed user also matches the group it's granted as.
+func ledgerMember(m Member) string {
+	if m.Guessed {
+		return m.ID
+	}
+	return m.String()
+}
+
+// Expire revokes the dataset grants in the ledger whose deadline has passed at now.
+func Expire(ctx context.Context, acl DatasetBackend, ledger *Ledger, now time.Time, opts Options) error {
+	due := ledger.Due(now)
+	if len(due) == 0 {
+		fmt.Println("No expired grants.")
+		return nil
+	}
+
+	fmt.Printf("REVOKE following expired roles\n")
+	for _, g := range due {
+		fmt.Printf("  %s\n", g)
+	}
+
+	if !opts.Yes && !confirm("Are you sure? [y/n]") {
+		fmt.Println("Abort.")
+		return nil
+	}
+
+	return expire(ctx, acl, ledger, due, opts)
+}
+
+// expire revokes the grants and removes them from the ledger.
+// A grant already revoked by someone else is just removed.
+func expire(ctx context.Context, acl DatasetBackend, ledger *Ledger, due []ExpiringGrant, opts Options) error {
+	var failed []string
+	for _, g := range due {
+		m, err := ParseMember(g.Member)
+		if err != nil {
+			return fmt.Errorf("invalid member in expiry ledger: %s", err)
+		}
+
+		var revoked bool
+		err = updateAccess(ctx, acl, opts.Retry, g.Project, g.Dataset, func(access *DatasetAccess) bool {
+			var entries []*bq.AccessEntry
+			for _, e := range access.Entries {
+				if e.Role == g.Role && m.matchesEntry(e) {
+					continue
+				}
+				entries = append(entries, e)
+			}
+			revoked = len(entries) < len(access.Entries)
+			access.Entries = entries
+			return revoked
+		})
+		if err != nil {
+			log.Warn().Msgf("failed to revoke %s: %s", g, err)
+			failed = append(failed, g.String())
+			continue
+		}
+
+		ledger.remove(g)
+		if !revoked {
+			log.Info().Msgf("%s is already revoked. skipped.", g)
+			continue
+		}
+		opts.printf("Revoked %s's permission of %s access as %s\n", m.ID, g.Dataset, g.Role)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to revoke some expired grants: %s", failed)
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package bqrole
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+)
+
+func TestParseExpires(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		input   string
+		want    time.Time
+		wantErr bool
+	}{
+		{input: "7d", want: now.AddDate(0, 0, 7)},
+		{input: "2w", want: now.AddDate(0, 0, 14)},
+		{input: "12h", want: now.Add(12 * time.Hour)},
+		{input: "0d", wantErr: true},
+		{input: "-1h", wantErr: true},
+		{input: "xd", wantErr: true},
+		{input: "week", wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.input, func(t *testing.T) {
+			got, err := ParseExpires(c.input, now)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("got err: %v, wantErr: %v", err, c.wantErr)
+			}
+			if !got.Equal(c.want) {
+				t.Errorf("got: %v, want: %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestPermitProjectExpires(t *testing.T) {
+	ctx := context.Background()
+	expires := time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)
+	condition := &Condition{
+		Title:       ExpiryConditionTitle,
+		Description: "granted by bqiam until 2024-01-08T00:00:00Z",
+		Expression:  `request.time < timestamp("2024-01-08T00:00:00Z")`,
+	}
+	iam := NewFakeIAMBackend(map[string]*ProjectPolicy{
+		"bq-project": {Bindings: []Binding{{Role: "roles/viewer", Members: []string{"user:owner@email.com"}}}},
+	})
+
+	opts := testOptions
+	opts.Expires = expires
+	members := mustParseMembers(t, []string{"user1@email.com", "owner@email.com"})
+	if err := PermitProject(ctx, iam, "roles/viewer", "bq-project", members, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	policy, _ := iam.GetPolicy(ctx, "bq-project")
+	want := []Binding{
+		{Role: "roles/viewer", Members: []string{"user:owner@email.com"}},
+		{Role: "roles/viewer", Members: []string{"user:user1@email.com"}, Condition: condition},
+	}
+	if !reflect.DeepEqual(policy.Bindings, want) {
+		t.Errorf("got: %v, want: %v", policy.Bindings, want)
+	}
+
+	// extending the deadline replaces the binding
+	opts.Expires = expires.AddDate(0, 0, 7)
+	if err := PermitProject(ctx, iam, "roles/viewer", "bq-project", members[:1], opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	policy, _ = iam.GetPolicy(ctx, "bq-project")
+	if got := len(policy.Bindings); got != 2 {
+		t.Fatalf("bindings got: %v, want 2 bindings", policy.Bindings)
+	}
+	if got, want := policy.Bindings[1].Condition.Expression, `request.time < timestamp("2024-01-15T00:00:00Z")`; got != want {
+		t.Errorf("condition got: %s, want: %s", got, want)
+	}
+
+	// revoke removes the time-bound binding too
+	if err := RevokeProject(ctx, iam, "roles/viewer", "bq-project", members[:1], testOptions); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	policy, _ = iam.GetPolicy(ctx, "bq-project")
+	if !reflect.DeepEqual(policy.Bindings, want[:1]) {
+		t.Errorf("got: %v, want: %v", policy.Bindings, want[:1])
+	}
+}
+
+func TestPermitDatasetExpires(t *testing.T) {
+	ctx := context.Background()
+	reader := &bq.AccessEntry{Role: bq.ReaderRole, EntityType: bq.UserEmailEntity, Entity: "reader@email.com"}
+	iam := NewFakeIAMBackend(map[string]*ProjectPolicy{"bq-project": {}})
+	acl := NewFakeDatasetBackend(map[string][]*bq.AccessEntry{
+		"bq-project.dataset1": {reader},
+		"bq-project.dataset2": {},
+	}, "group@email.com")
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ledger := &Ledger{}
+	opts := testOptions
+	opts.Expires, opts.Ledger = now.AddDate(0, 0, 7), ledger
+	members := mustParseMembers(t, []string{"user1@email.com", "group@email.com", "reader@email.com"})
+	if err := PermitDataset(ctx, iam, acl, bq.ReaderRole, "bq-project", members, []string{"dataset1"}, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	opts.Expires = now.AddDate(0, 0, 30)
+	if err := PermitDataset(ctx, iam, acl, bq.ReaderRole, "bq-project", members[:1], []string{"dataset2"}, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the permanent grant of reader@email.com is not recorded
+	want := []ExpiringGrant{
+		{Project: "bq-project", Dataset: "dataset1", Role: bq.ReaderRole, Member: "user1@email.com", Expires: now.AddDate(0, 0, 7)},
+		{Project: "bq-project", Dataset: "dataset1", Role: bq.ReaderRole, Member: "group@email.com", Expires: now.AddDate(0, 0, 7)},
+		{Project: "bq-project", Dataset: "dataset2", Role: bq.ReaderRole, Member: "user1@email.com", Expires: now.AddDate(0, 0, 30)},
+	}
+	if !reflect.DeepEqual(ledger.Grants, want) {
+		t.Fatalf("ledger got: %v, want: %v", ledger.Grants, want)
+	}
+
+	file := filepath.Join(t.TempDir(), "ledger.toml")
+	if err := ledger.Save(file); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	loaded, err := LoadLedger(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(loaded.Grants, want) {
+		t.Errorf("loaded ledger got: %v, want: %v", loaded.Grants, want)
+	}
+
+	// only the grants on dataset1 are due
+	if err := Expire(ctx, acl, ledger, now.AddDate(0, 0, 8), testOptions); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(ledger.Grants, want[2:]) {
+		t.Errorf("ledger got: %v, want: %v", ledger.Grants, want[2:])
+	}
+
+	access, _ := acl.GetAccess(ctx, "bq-project", "dataset1")
+	if want := []*bq.AccessEntry{reader}; !reflect.DeepEqual(access.Entries, want) {
+		t.Errorf("access got: %v, want: %v", access.Entries, want)
+	}
+	access, _ = acl.GetAccess(ctx, "bq-project", "dataset2")
+	if len(access.Entries) != 1 {
+		t.Errorf("access got: %v, want the grant not expired yet", access.Entries)
+	}
+}
+
+func TestLoadLedgerNotExist(t *testing.T) {
+	ledger, err := LoadLedger(filepath.Join(t.TempDir(), "ledger.toml"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ledger.Grants) != 0 {
+		t.Errorf("got: %v, want an empty ledger", ledger.Grants)
+	}
+}
+
+func TestPermitDatasetExpiresKeepsLaterDeadline(t *testing.T) {
+	ctx := context.Background()
+	iam := NewFakeIAMBackend(map[string]*ProjectPolicy{"bq-project": {}})
+	acl := NewFakeDatasetBackend(map[string][]*bq.AccessEntry{
+		"bq-project.dataset1": {},
+		"bq-project.dataset2": {},
+	})
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ledger := &Ledger{}
+	opts := testOptions
+	opts.Ledger = ledger
+	members := mustParseMembers(t, []string{"user:user1@email.com"})
+
+	// 30 days on dataset1, then 1 day on dataset2, and 1 day on dataset1 again
+	for _, grant := range []struct {
+		dataset string
+		expires time.Time
+	}{
+		{dataset: "dataset1", expires: now.AddDate(0, 0, 30)},
+		{dataset: "dataset2", expires: now.AddDate(0, 0, 1)},
+		{dataset: "dataset1", expires: now.AddDate(0, 0, 1)},
+	} {
+		opts.Expires = grant.expires
+		if err := PermitDataset(ctx, iam, acl, bq.ReaderRole, "bq-project", members, []string{grant.dataset}, opts); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// the project roles shared by the datasets last until the later deadline
+	condition := expiryCondition(now.AddDate(0, 0, 30))
+	policy, _ := iam.GetPolicy(ctx, "bq-project")
+	want := []Binding{
+		{Role: "roles/bigquery.jobUser", Members: []string{"user:user1@email.com"}, Condition: condition},
+		{Role: "roles/bigquery.user", Members: []string{"user:user1@email.com"}, Condition: condition},
+	}
+	if !reflect.DeepEqual(policy.Bindings, want) {
+		t.Errorf("bindings got: %v, want: %v", policy.Bindings, want)
+	}
+
+	// the shorter deadline doesn't shorten the grant on dataset1
+	wantGrants := []ExpiringGrant{
+		{Project: "bq-project", Dataset: "dataset1", Role: bq.ReaderRole, Member: "user:user1@email.com", Expires: now.AddDate(0, 0, 30)},
+		{Project: "bq-project", Dataset: "dataset2", Role: bq.ReaderRole, Member: "user:user1@email.com", Expires: now.AddDate(0, 0, 1)},
+	}
+	if !reflect.DeepEqual(ledger.Grants, wantGrants) {
+		t.Errorf("ledger got: %v, want: %v", ledger.Grants, wantGrants)
+	}
+}
+
+func TestPermitDatasetPermanentAfterExpires(t *testing.T) {
+	ctx := context.Background()
+	iam := NewFakeIAMBackend(map[string]*ProjectPolicy{"bq-project": {}})
+	acl := NewFakeDatasetBackend(map[string][]*bq.AccessEntry{"bq-project.dataset1": {}})
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ledger := &Ledger{}
+	opts := testOptions
+	opts.Expires, opts.Ledger = now.AddDate(0, 0, 7), ledger
+	if err := PermitDataset(ctx, iam, acl, bq.ReaderRole, "bq-project", mustParseMembers(t, []string{"user1@email.com"}), []string{"dataset1"}, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// permitting it again permanently, as an explicit user this time
+	opts.Expires = time.Time{}
+	if err := PermitDataset(ctx, iam, acl, bq.ReaderRole, "bq-project", mustParseMembers(t, []string{"user:user1@email.com"}), []string{"dataset1"}, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ledger.Grants) != 0 {
+		t.Fatalf("ledger got: %v, want the permanent grant removed", ledger.Grants)
+	}
+
+	// expire keeps the permanent grant
+	if err := Expire(ctx, acl, ledger, now.AddDate(0, 0, 8), testOptions); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	access, _ := acl.GetAccess(ctx, "bq-project", "dataset1")
+	want := []*bq.AccessEntry{{Role: bq.ReaderRole, EntityType: bq.UserEmailEntity, Entity: "user1@email.com"}}
+	if !reflect.DeepEqual(access.Entries, want) {
+		t.Errorf("access got: %v, want: %v", access.Entries, want)
+	}
+}
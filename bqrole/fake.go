@@ -0,0 +1,162 @@
+package bqrole
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	bq "cloud.google.com/go/bigquery"
+	"google.golang.org/api/googleapi"
+)
+
+// FakeIAMBackend is an in-memory IAMBackend to run permit/revoke flows without GCP.
+type FakeIAMBackend struct {
+	mu       sync.Mutex
+	policies map[string]*ProjectPolicy
+	revision int
+	sets     int
+}
+
+// NewFakeIAMBackend returns a FakeIAMBackend holding policies keyed by project.
+func NewFakeIAMBackend(policies map[string]*ProjectPolicy) *FakeIAMBackend {
+	f := &FakeIAMBackend{policies: map[string]*ProjectPolicy{}}
+	for project, p := range policies {
+		p = copyPolicy(p)
+		p.Etag = f.nextEtag()
+		f.policies[project] = p
+	}
+	return f
+}
+
+func (f *FakeIAMBackend) GetPolicy(ctx context.Context, project string) (*ProjectPolicy, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, ok := f.policies[project]
+	if !ok {
+		return nil, fmt.Errorf("project %s not found", project)
+	}
+	return copyPolicy(p), nil
+}
+
+func (f *FakeIAMBackend) SetPolicy(ctx context.Context, project string, policy *ProjectPolicy) (*ProjectPolicy, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	current, ok := f.policies[project]
+	if !ok {
+		return nil, fmt.Errorf("project %s not found", project)
+	}
+	if policy.Etag != "" && policy.Etag != current.Etag {
+		return nil, fmt.Errorf("%w: project %s", ErrPolicyConflict, project)
+	}
+
+	f.sets++
+	p := copyPolicy(policy)
+	p.Etag = f.nextEtag()
+	f.policies[project] = p
+	return copyPolicy(p), nil
+}
+
+// SetPolicyCalls returns the number of policies written so far.
+func (f *FakeIAMBackend) SetPolicyCalls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.sets
+}
+
+func (f *FakeIAMBackend) nextEtag() string {
+	f.revision++
+	return strconv.Itoa(f.revision)
+}
+
+func copyPolicy(p *ProjectPolicy) *ProjectPolicy {
+	c := &ProjectPolicy{Etag: p.Etag, Version: p.Version}
+	for _, b := range p.Bindings {
+		binding := Binding{Role: b.Role, Members: append([]string(nil), b.Members...)}
+		if b.Condition != nil {
+			cond := *b.Condition
+			binding.Condition = &cond
+		}
+		c.Bindings = append(c.Bindings, binding)
+	}
+	return c
+}
+
+// FakeDatasetBackend is an in-memory DatasetBackend to run permit/revoke flows without BigQuery.
+type FakeDatasetBackend struct {
+	mu       sync.Mutex
+	datasets map[string]*DatasetAccess
+	groups   map[string]bool
+	revision int
+	sets     int
+}
+
+// NewFakeDatasetBackend returns a FakeDatasetBackend holding access lists keyed by "project.dataset".
+// Emails in groups are rejected when they are granted as bq.UserEmailEntity, as BigQuery does.
+func NewFakeDatasetBackend(datasets map[string][]*bq.AccessEntry, groups ...string) *FakeDatasetBackend {
+	f := &FakeDatasetBackend{datasets: map[string]*DatasetAccess{}, groups: map[string]bool{}}
+	for id, entries := range datasets {
+		f.datasets[id] = &DatasetAccess{Entries: copyEntries(entries), ETag: f.nextETag()}
+	}
+	for _, g := range groups {
+		f.groups[g] = true
+	}
+	return f
+}
+
+func (f *FakeDatasetBackend) GetAccess(ctx context.Context, project, dataset string) (*DatasetAccess, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	access, ok := f.datasets[project+"."+dataset]
+	if !ok {
+		return nil, &googleapi.Error{Code: http.StatusNotFound, Message: fmt.Sprintf("Not found: Dataset %s:%s", project, dataset)}
+	}
+	return &DatasetAccess{Entries: copyEntries(access.Entries), ETag: access.ETag}, nil
+}
+
+func (f *FakeDatasetBackend) SetAccess(ctx context.Context, project, dataset string, access *DatasetAccess) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	current, ok := f.datasets[project+"."+dataset]
+	if !ok {
+		return &googleapi.Error{Code: http.StatusNotFound, Message: fmt.Sprintf("Not found: Dataset %s:%s", project, dataset)}
+	}
+	if access.ETag != "" && access.ETag != current.ETag {
+		return fmt.Errorf("%w: dataset %s:%s", ErrDatasetConflict, project, dataset)
+	}
+	for _, e := range access.Entries {
+		if e.EntityType == bq.UserEmailEntity && f.groups[e.Entity] {
+			return &googleapi.Error{Code: http.StatusBadRequest, Message: fmt.Sprintf("Invalid user email: %s", e.Entity)}
+		}
+	}
+
+	f.sets++
+	f.datasets[project+"."+dataset] = &DatasetAccess{Entries: copyEntries(access.Entries), ETag: f.nextETag()}
+	return nil
+}
+
+// SetAccessCalls returns the number of access lists written so far.
+func (f *FakeDatasetBackend) SetAccessCalls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.sets
+}
+
+func (f *FakeDatasetBackend) nextETag() string {
+	f.revision++
+	return strconv.Itoa(f.revision)
+}
+
+func copyEntries(entries []*bq.AccessEntry) []*bq.AccessEntry {
+	var c []*bq.AccessEntry
+	for _, e := range entries {
+		entry := *e
+		c = append(c, &entry)
+	}
+	return c
+}
@@ -0,0 +1,230 @@
+package bqrole
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+	crm "google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// ErrPolicyConflict is returned by IAMBackend.SetPolicy when the policy was modified
+// since it was read, i.e. its etag no longer matches.
+var ErrPolicyConflict = errors.New("iam policy was modified concurrently")
+
+// IAMBackend reads and writes the IAM policy of GCP projects.
+type IAMBackend interface {
+	GetPolicy(ctx context.Context, project string) (*ProjectPolicy, error)
+	SetPolicy(ctx context.Context, project string, policy *ProjectPolicy) (*ProjectPolicy, error)
+}
+
+type ProjectPolicy struct {
+	Bindings []Binding `json:"bindings"`
+	Etag     string    `json:"etag"`
+	Version  int       `json:"version"`
+}
+
+type Binding struct {
+	Role      string     `json:"role"`
+	Members   []string   `json:"members"`
+	Condition *Condition `json:"condition,omitempty"`
+}
+
+type Condition struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Expression  string `json:"expression"`
+}
+
+func FetchCurrentPolicy(ctx context.Context, iam IAMBackend, project string) (*ProjectPolicy, error) {
+	log.Info().Msgf("fetch iam policy: %s", project)
+
+	policy, err := iam.GetPolicy(ctx, project)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current iam policy: %s", err)
+	}
+
+	log.Info().Msgf("finish: fetch iam policy: %s", project)
+	return policy, nil
+}
+
+// addMember adds member to the unconditional binding of role.
+// It returns false if the member is already bound.
+func (p *ProjectPolicy) addMember(role, member string) bool {
+	return p.addConditionalMember(role, member, nil)
+}
+
+// addConditionalMember adds member to the binding of role with the condition, or the unconditional one if nil.
+// It returns false if the member is already bound.
+func (p *ProjectPolicy) addConditionalMember(role, member string, condition *Condition) bool {
+	for i, b := range p.Bindings {
+		if b.Role != role || !sameCondition(b.Condition, condition) {
+			continue
+		}
+		for _, m := range b.Members {
+			if m == member {
+				return false
+			}
+		}
+		p.Bindings[i].Members = append(p.Bindings[i].Members, member)
+		return true
+	}
+
+	p.Bindings = append(p.Bindings, Binding{Role: role, Members: []string{member}, Condition: condition})
+	return true
+}
+
+func sameCondition(a, b *Condition) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Title == b.Title && a.Expression == b.Expression
+}
+
+// removeMember removes member from the unconditional binding of role.
+// It returns false if the member is not bound.
+func (p *ProjectPolicy) removeMember(role, member string) bool {
+	return p.removeMemberIf(role, member, func(c *Condition) bool { return c == nil })
+}
+
+// removeMemberIf removes member from the bindings of role whose condition matches.
+// It returns false if the member is not bound.
+func (p *ProjectPolicy) removeMemberIf(role, member string, match func(*Condition) bool) bool {
+	removed := false
+	var bindings []Binding
+	for _, b := range p.Bindings {
+		if b.Role == role && match(b.Condition) {
+			var members []string
+			for _, m := range b.Members {
+				if m == member {
+					removed = true
+					continue
+				}
+				members = append(members, m)
+			}
+			if len(members) == 0 {
+				continue
+			}
+			b.Members = members
+		}
+		bindings = append(bindings, b)
+	}
+	p.Bindings = bindings
+	return removed
+}
+
+// ResourceManagerBackend is an IAMBackend using the Cloud Resource Manager API.
+type ResourceManagerBackend struct {
+	service *crm.Service
+}
+
+func NewResourceManagerBackend(ctx context.Context) (*ResourceManagerBackend, error) {
+	service, err := crm.NewService(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource manager service: %w", err)
+	}
+	return &ResourceManagerBackend{service: service}, nil
+}
+
+func (b *ResourceManagerBackend) GetPolicy(ctx context.Context, project string) (*ProjectPolicy, error) {
+	req := &crm.GetIamPolicyRequest{
+		Options: &crm.GetPolicyOptions{RequestedPolicyVersion: 3}, // keep conditional bindings intact
+	}
+	policy, err := b.service.Projects.GetIamPolicy(project, req).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return fromAPIPolicy(policy), nil
+}
+
+func (b *ResourceManagerBackend) SetPolicy(ctx context.Context, project string, policy *ProjectPolicy) (*ProjectPolicy, error) {
+	req := &crm.SetIamPolicyRequest{Policy: toAPIPolicy(policy)}
+	updated, err := b.service.Projects.SetIamPolicy(project, req).Context(ctx).Do()
+	if isConflict(err) {
+		return nil, fmt.Errorf("%w: %s", ErrPolicyConflict, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fromAPIPolicy(updated), nil
+}
+
+func fromAPIPolicy(policy *crm.Policy) *ProjectPolicy {
+	p := &ProjectPolicy{
+		Etag:    policy.Etag,
+		Version: int(policy.Version),
+	}
+	for _, b := range policy.Bindings {
+		binding := Binding{Role: b.Role, Members: b.Members}
+		if b.Condition != nil {
+			binding.Condition = &Condition{
+				Title:       b.Condition.Title,
+				Description: b.Condition.Description,
+				Expression:  b.Condition.Expression,
+			}
+		}
+		p.Bindings = append(p.Bindings, binding)
+	}
+	return p
+}
+
+func toAPIPolicy(p *ProjectPolicy) *crm.Policy {
+	policy := &crm.Policy{
+		Etag:    p.Etag,
+		Version: int64(p.Version),
+	}
+	for _, b := range p.Bindings {
+		binding := &crm.Binding{Role: b.Role, Members: b.Members}
+		if b.Condition != nil {
+			binding.Condition = &crm.Expr{
+				Title:       b.Condition.Title,
+				Description: b.Condition.Description,
+				Expression:  b.Condition.Expression,
+			}
+			policy.Version = 3 // conditional bindings require policy version 3
+		}
+		policy.Bindings = append(policy.Bindings, binding)
+	}
+	return policy
+}
+
+// isInvalidArgument reports whether err is an INVALID_ARGUMENT response,
+// which IAM returns e.g. when a group account is bound as a user.
+func isInvalidArgument(err error) bool {
+	var gerr *googleapi.Error
+	return errors.As(err, &gerr) && gerr.Code == http.StatusBadRequest
+}
+
+// isConflict reports whether err is an ABORTED response,
+// which IAM returns when the etag of the policy doesn't match.
+func isConflict(err error) bool {
+	var gerr *googleapi.Error
+	return errors.As(err, &gerr) && gerr.Code == http.StatusConflict
+}
+
+// updatePolicy reads the current policy of the project, applies mutate to it
+// and writes it back guarded by its etag, in a single setIamPolicy call.
+// mutate returns false if there is nothing to write.
+// The whole read-modify-write is retried when the policy is modified concurrently.
+func updatePolicy(ctx context.Context, iam IAMBackend, retry RetryPolicy, project string, mutate func(*ProjectPolicy) bool) error {
+	return retry.Do(ctx, isPolicyConflict, func() error {
+		policy, err := FetchCurrentPolicy(ctx, iam, project)
+		if err != nil {
+			return err
+		}
+
+		if !mutate(policy) {
+			return nil
+		}
+
+		_, err = iam.SetPolicy(ctx, project, policy)
+		return err
+	})
+}
+
+func isPolicyConflict(err error) bool {
+	return errors.Is(err, ErrPolicyConflict)
+}
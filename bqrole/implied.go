@@ -0,0 +1,46 @@
+package bqrole
+
+import (
+	"sort"
+
+	bq "cloud.google.com/go/bigquery"
+)
+
+// impliedDatasetRoles maps project roles to the dataset role they grant on every dataset of the project.
+var impliedDatasetRoles = map[string]bq.AccessRole{
+	"roles/owner":               bq.OwnerRole,
+	"roles/bigquery.admin":      bq.OwnerRole,
+	"roles/bigquery.dataOwner":  bq.OwnerRole,
+	"roles/editor":              bq.WriterRole,
+	"roles/bigquery.dataEditor": bq.WriterRole,
+	"roles/viewer":              bq.ReaderRole,
+	"roles/bigquery.dataViewer": bq.ReaderRole,
+}
+
+// ImpliedDatasetRole returns the dataset role that the project role implicitly grants on the datasets of the project.
+func ImpliedDatasetRole(projectRole string) (bq.AccessRole, bool) {
+	role, ok := impliedDatasetRoles[projectRole]
+	return role, ok
+}
+
+// ImpliedProjectRoles returns the project roles that implicitly grant any of roles on the datasets of the project,
+// or all of those project roles if roles is empty.
+func ImpliedProjectRoles(roles ...bq.AccessRole) []string {
+	var res []string
+	for projectRole, role := range impliedDatasetRoles {
+		if len(roles) == 0 || containsRole(roles, role) {
+			res = append(res, projectRole)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func containsRole(roles []bq.AccessRole, role bq.AccessRole) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package bqrole
+
+import (
+	"reflect"
+	"testing"
+
+	bq "cloud.google.com/go/bigquery"
+)
+
+func TestImpliedDatasetRole(t *testing.T) {
+	cases := []struct {
+		role   string
+		want   bq.AccessRole
+		wantOK bool
+	}{
+		{role: "roles/owner", want: bq.OwnerRole, wantOK: true},
+		{role: "roles/bigquery.admin", want: bq.OwnerRole, wantOK: true},
+		{role: "roles/editor", want: bq.WriterRole, wantOK: true},
+		{role: "roles/bigquery.dataEditor", want: bq.WriterRole, wantOK: true},
+		{role: "roles/viewer", want: bq.ReaderRole, wantOK: true},
+		{role: "roles/bigquery.dataViewer", want: bq.ReaderRole, wantOK: true},
+		{role: "roles/bigquery.jobUser", wantOK: false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.role, func(t *testing.T) {
+			got, ok := ImpliedDatasetRole(c.role)
+			if got != c.want || ok != c.wantOK {
+				t.Errorf("got: %s, %v, want: %s, %v", got, ok, c.want, c.wantOK)
+			}
+		})
+	}
+}
+
+func TestImpliedProjectRoles(t *testing.T) {
+	want := []string{"roles/bigquery.dataViewer", "roles/viewer"}
+	if got := ImpliedProjectRoles(bq.ReaderRole); !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+	if got := ImpliedProjectRoles(); len(got) != len(impliedDatasetRoles) {
+		t.Errorf("got: %v, want all of %v", got, impliedDatasetRoles)
+	}
+}
@@ -0,0 +1,118 @@
+package bqrole
+
+import (
+	"fmt"
+	"strings"
+
+	bq "cloud.google.com/go/bigquery"
+)
+
+const (
+	UserMember           = "user"
+	GroupMember          = "group"
+	ServiceAccountMember = "serviceAccount"
+	DomainMember         = "domain"
+)
+
+// Member is a principal to grant or revoke access, like user:user1@email.com.
+type Member struct {
+	Type string // one of UserMember, GroupMember, ServiceAccountMember or DomainMember
+	ID   string // email or domain
+
+	// Guessed is true if Type was guessed from a bare email.
+	// With Options.DetectMemberType, a guessed user is retried as a group when it's rejected.
+	Guessed bool
+}
+
+// ParseMember parses member syntax (user:|group:|serviceAccount:|domain:)[email or domain].
+// A bare email is taken as a service account if it looks like one, otherwise as a user.
+func ParseMember(s string) (Member, error) {
+	typ, id, ok := strings.Cut(s, ":")
+	if !ok {
+		if isServiceAccount(s) {
+			return Member{Type: ServiceAccountMember, ID: s, Guessed: true}, nil
+		}
+		return Member{Type: UserMember, ID: s, Guessed: true}, nil
+	}
+
+	switch typ {
+	case UserMember, GroupMember, ServiceAccountMember, DomainMember:
+	default:
+		return Member{}, fmt.Errorf("unknown member type %q in %s (must be one of user, group, serviceAccount or domain)", typ, s)
+	}
+	if id == "" {
+		return Member{}, fmt.Errorf("empty member: %s", s)
+	}
+
+	return Member{Type: typ, ID: id}, nil
+}
+
+func ParseMembers(ss []string) ([]Member, error) {
+	var members []Member
+	for _, s := range ss {
+		m, err := ParseMember(s)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+// String returns the IAM policy member, formatted as (user|group|serviceAccount|domain):[email or domain]
+func (m Member) String() string {
+	return m.Type + ":" + m.ID
+}
+
+// EntityType returns the entity type of m in dataset access entries.
+// Service accounts are granted by email as users.
+func (m Member) EntityType() bq.EntityType {
+	switch m.Type {
+	case GroupMember:
+		return bq.GroupEmailEntity
+	case DomainMember:
+		return bq.DomainEntity
+	}
+	return bq.UserEmailEntity
+}
+
+// asGroup returns m as a group account.
+func (m Member) asGroup() Member {
+	return Member{Type: GroupMember, ID: m.ID}
+}
+
+// canBeGroup reports whether m may be a group account that was taken as a user.
+func (m Member) canBeGroup(opts Options) bool {
+	return opts.DetectMemberType && m.Guessed && m.Type == UserMember
+}
+
+// matches reports whether the IAM policy member belongs to m.
+// A guessed member matches any member type with the same email.
+func (m Member) matches(member string) bool {
+	if m.Guessed {
+		return memberID(member) == m.ID
+	}
+	return member == m.String()
+}
+
+// matchesEntry reports whether the dataset access entry is granted to m.
+func (m Member) matchesEntry(e *bq.AccessEntry) bool {
+	switch e.EntityType {
+	case bq.UserEmailEntity, bq.GroupEmailEntity, bq.DomainEntity:
+		if m.Guessed {
+			return e.Entity == m.ID && e.EntityType != bq.DomainEntity
+		}
+		return e.Entity == m.ID && e.EntityType == m.EntityType()
+	case bq.IAMMemberEntity:
+		return m.matches(e.Entity)
+	}
+	return false
+}
+
+func memberIDs(members []Member) []string {
+	var ids []string
+	for _, m := range members {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
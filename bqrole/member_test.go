@@ -0,0 +1,68 @@
+package bqrole
+
+import (
+	"testing"
+)
+
+func TestParseMember(t *testing.T) {
+	cases := []struct {
+		name    string
+		input   string
+		want    Member
+		wantErr bool
+	}{
+		{
+			name:  "bare email",
+			input: "user1@email.com",
+			want:  Member{Type: UserMember, ID: "user1@email.com", Guessed: true},
+		},
+		{
+			name:  "bare service account",
+			input: "sa@bq-project.iam.gserviceaccount.com",
+			want:  Member{Type: ServiceAccountMember, ID: "sa@bq-project.iam.gserviceaccount.com", Guessed: true},
+		},
+		{
+			name:  "user",
+			input: "user:user1@email.com",
+			want:  Member{Type: UserMember, ID: "user1@email.com"},
+		},
+		{
+			name:  "group",
+			input: "group:group@email.com",
+			want:  Member{Type: GroupMember, ID: "group@email.com"},
+		},
+		{
+			name:  "service account",
+			input: "serviceAccount:sa@bq-project.iam.gserviceaccount.com",
+			want:  Member{Type: ServiceAccountMember, ID: "sa@bq-project.iam.gserviceaccount.com"},
+		},
+		{
+			name:  "domain",
+			input: "domain:email.com",
+			want:  Member{Type: DomainMember, ID: "email.com"},
+		},
+		{
+			name:    "unknown type",
+			input:   "team:group@email.com",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			input:   "group:",
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := ParseMember(c.input)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got != c.want {
+				t.Errorf("got: %+v, want: %+v", got, c.want)
+			}
+		})
+	}
+}
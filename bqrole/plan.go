@@ -0,0 +1,257 @@
+package bqrole
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	bq "cloud.google.com/go/bigquery"
+)
+
+const (
+	AddAction    = "add"
+	RemoveAction = "remove"
+)
+
+// BindingChange is a change to the IAM policy of a project.
+type BindingChange struct {
+	Action    string `json:"action"`
+	Project   string `json:"project"`
+	Role      string `json:"role"`
+	Member    string `json:"member"`
+	Condition string `json:"condition,omitempty"`
+}
+
+// AccessChange is a change to the access list of a dataset.
+type AccessChange struct {
+	Action     string `json:"action"`
+	Project    string `json:"project"`
+	Dataset    string `json:"dataset"`
+	Role       string `json:"role"`
+	EntityType string `json:"entityType"`
+	Entity     string `json:"entity"`
+}
+
+// Plan is the set of changes a run would make, diffed against the current state.
+type Plan struct {
+	Bindings []BindingChange `json:"bindings"`
+	Access   []AccessChange  `json:"access"`
+}
+
+func (p *Plan) IsEmpty() bool {
+	return len(p.Bindings) == 0 && len(p.Access) == 0
+}
+
+// Print writes the plan in a human readable form.
+func (p *Plan) Print(w io.Writer) {
+	if p.IsEmpty() {
+		fmt.Fprintln(w, "No changes.")
+		return
+	}
+
+	for _, c := range p.Bindings {
+		fmt.Fprintf(w, "%s project %s: %s %s", actionSign(c.Action), c.Project, c.Role, c.Member)
+		if c.Condition != "" {
+			fmt.Fprintf(w, " (condition: %s)", c.Condition)
+		}
+		fmt.Fprintln(w)
+	}
+	for _, c := range p.Access {
+		fmt.Fprintf(w, "%s dataset %s.%s: %s %s:%s\n", actionSign(c.Action), c.Project, c.Dataset, c.Role, c.EntityType, c.Entity)
+	}
+	fmt.Fprintf(w, "Plan: %d to add, %d to remove.\n", p.count(AddAction), p.count(RemoveAction))
+}
+
+// WriteJSON writes the plan in JSON for machine review.
+func (p *Plan) WriteJSON(w io.Writer) error {
+	out := *p
+	if out.Bindings == nil {
+		out.Bindings = []BindingChange{} // write [] rather than null
+	}
+	if out.Access == nil {
+		out.Access = []AccessChange{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(&out)
+}
+
+func (p *Plan) count(action string) int {
+	n := 0
+	for _, c := range p.Bindings {
+		if c.Action == action {
+			n++
+		}
+	}
+	for _, c := range p.Access {
+		if c.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+func actionSign(action string) string {
+	if action == RemoveAction {
+		return "-"
+	}
+	return "+"
+}
+
+// PlanPermitProject returns the changes PermitProject would make without making them.
+func PlanPermitProject(ctx context.Context, iam IAMBackend, role, project string, members []Member, opts Options) (*Plan, error) {
+	plan := &Plan{}
+	err := permitProject(ctx, newPlanIAMBackend(iam, plan), role, project, members, opts.forPlan())
+	return plan, err
+}
+
+// PlanRevokeProject returns the changes RevokeProject would make without making them.
+func PlanRevokeProject(ctx context.Context, iam IAMBackend, role, project string, members []Member, opts Options) (*Plan, error) {
+	plan := &Plan{}
+	err := revokeProject(ctx, newPlanIAMBackend(iam, plan), role, project, members, opts.forPlan())
+	return plan, err
+}
+
+// PlanPermitDataset returns the changes PermitDataset would make without making them.
+func PlanPermitDataset(ctx context.Context, iam IAMBackend, acl DatasetBackend, role bq.AccessRole, project string, members []Member, datasets []string, opts Options) (*Plan, error) {
+	plan := &Plan{}
+	err := permitDataset(ctx, newPlanIAMBackend(iam, plan), newPlanDatasetBackend(acl, plan), role, project, members, datasets, opts.forPlan())
+	return plan, err
+}
+
+// PlanRevokeDataset returns the changes RevokeDataset would make without making them.
+func PlanRevokeDataset(ctx context.Context, acl DatasetBackend, role bq.AccessRole, project string, members []Member, datasets []string, opts Options) (*Plan, error) {
+	plan := &Plan{}
+	err := revokeDataset(ctx, newPlanDatasetBackend(acl, plan), role, project, members, datasets, opts.forPlan())
+	return plan, err
+}
+
+// planIAMBackend records the changes to the plan instead of writing policies.
+type planIAMBackend struct {
+	IAMBackend
+	plan *Plan
+}
+
+func newPlanIAMBackend(iam IAMBackend, plan *Plan) *planIAMBackend {
+	return &planIAMBackend{IAMBackend: iam, plan: plan}
+}
+
+func (b *planIAMBackend) SetPolicy(ctx context.Context, project string, policy *ProjectPolicy) (*ProjectPolicy, error) {
+	current, err := b.IAMBackend.GetPolicy(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+	b.plan.Bindings = append(b.plan.Bindings, diffPolicy(project, current, policy)...)
+	return policy, nil
+}
+
+// planDatasetBackend records the changes to the plan instead of writing access lists.
+type planDatasetBackend struct {
+	DatasetBackend
+	plan *Plan
+}
+
+func newPlanDatasetBackend(acl DatasetBackend, plan *Plan) *planDatasetBackend {
+	return &planDatasetBackend{DatasetBackend: acl, plan: plan}
+}
+
+func (b *planDatasetBackend) SetAccess(ctx context.Context, project, dataset string, access *DatasetAccess) error {
+	current, err := b.DatasetBackend.GetAccess(ctx, project, dataset)
+	if err != nil {
+		return err
+	}
+	b.plan.Access = append(b.plan.Access, diffAccess(project, dataset, current.Entries, access.Entries)...)
+	return nil
+}
+
+type bindingKey struct {
+	role, member, condition string
+}
+
+func bindingKeys(p *ProjectPolicy) []bindingKey {
+	var keys []bindingKey
+	for _, b := range p.Bindings {
+		var condition string
+		if b.Condition != nil {
+			condition = b.Condition.Expression
+		}
+		for _, m := range b.Members {
+			keys = append(keys, bindingKey{role: b.Role, member: m, condition: condition})
+		}
+	}
+	return keys
+}
+
+func diffPolicy(project string, before, after *ProjectPolicy) []BindingChange {
+	var changes []BindingChange
+	for _, d := range diffKeys(bindingKeys(before), bindingKeys(after)) {
+		changes = append(changes, BindingChange{
+			Action:    d.action,
+			Project:   project,
+			Role:      d.key.role,
+			Member:    d.key.member,
+			Condition: d.key.condition,
+		})
+	}
+	return changes
+}
+
+type accessKey struct {
+	role, entityType, entity string
+}
+
+func accessKeys(entries []*bq.AccessEntry) []accessKey {
+	var keys []accessKey
+	for _, e := range entries {
+		keys = append(keys, accessKey{role: string(e.Role), entityType: EntityTypeName(e.EntityType), entity: EntityName(e)})
+	}
+	return keys
+}
+
+func diffAccess(project, dataset string, before, after []*bq.AccessEntry) []AccessChange {
+	var changes []AccessChange
+	for _, d := range diffKeys(accessKeys(before), accessKeys(after)) {
+		changes = append(changes, AccessChange{
+			Action:     d.action,
+			Project:    project,
+			Dataset:    dataset,
+			Role:       d.key.role,
+			EntityType: d.key.entityType,
+			Entity:     d.key.entity,
+		})
+	}
+	return changes
+}
+
+type keyDiff[K comparable] struct {
+	action string
+	key    K
+}
+
+// diffKeys returns keys only in after as added, then keys only in before as removed.
+func diffKeys[K comparable](before, after []K) []keyDiff[K] {
+	inBefore := map[K]bool{}
+	for _, k := range before {
+		inBefore[k] = true
+	}
+	inAfter := map[K]bool{}
+	for _, k := range after {
+		inAfter[k] = true
+	}
+
+	var diffs []keyDiff[K]
+	for _, k := range after {
+		if !inBefore[k] {
+			diffs = append(diffs, keyDiff[K]{action: AddAction, key: k})
+			inBefore[k] = true // report duplicates once
+		}
+	}
+	for _, k := range before {
+		if !inAfter[k] {
+			diffs = append(diffs, keyDiff[K]{action: RemoveAction, key: k})
+			inAfter[k] = true
+		}
+	}
+	return diffs
+}
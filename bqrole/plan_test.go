@@ -0,0 +1,102 @@
+package bqrole
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	bq "cloud.google.com/go/bigquery"
+)
+
+func TestPlanPermitDataset(t *testing.T) {
+	ctx := context.Background()
+	iam := NewFakeIAMBackend(map[string]*ProjectPolicy{
+		"bq-project": {Bindings: []Binding{{Role: "roles/bigquery.jobUser", Members: []string{"user:reader@email.com"}}}},
+	})
+	acl := NewFakeDatasetBackend(map[string][]*bq.AccessEntry{
+		"bq-project.dataset1": {{Role: bq.ReaderRole, EntityType: bq.UserEmailEntity, Entity: "reader@email.com"}},
+	})
+
+	plan, err := PlanPermitDataset(ctx, iam, acl, bq.ReaderRole, "bq-project",
+		mustParseMembers(t, []string{"reader@email.com", "group:group@email.com"}), []string{"dataset1"}, testOptions)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := &Plan{
+		Bindings: []BindingChange{
+			{Action: AddAction, Project: "bq-project", Role: "roles/bigquery.jobUser", Member: "group:group@email.com"},
+			{Action: AddAction, Project: "bq-project", Role: "roles/bigquery.user", Member: "user:reader@email.com"},
+			{Action: AddAction, Project: "bq-project", Role: "roles/bigquery.user", Member: "group:group@email.com"},
+		},
+		Access: []AccessChange{
+			{Action: AddAction, Project: "bq-project", Dataset: "dataset1", Role: "READER", EntityType: "group", Entity: "group@email.com"},
+		},
+	}
+	if !reflect.DeepEqual(plan, want) {
+		t.Errorf("got: %+v, want: %+v", plan, want)
+	}
+
+	if iam.SetPolicyCalls() != 0 || acl.SetAccessCalls() != 0 {
+		t.Errorf("plan must not write: setIamPolicy %d, dataset update %d", iam.SetPolicyCalls(), acl.SetAccessCalls())
+	}
+}
+
+func TestPlanRevokeDataset(t *testing.T) {
+	ctx := context.Background()
+	acl := NewFakeDatasetBackend(map[string][]*bq.AccessEntry{
+		"bq-project.dataset1": {{Role: bq.ReaderRole, EntityType: bq.UserEmailEntity, Entity: "reader@email.com"}},
+		"bq-project.dataset2": nil,
+	})
+
+	plan, err := PlanRevokeDataset(ctx, acl, bq.ReaderRole, "bq-project",
+		mustParseMembers(t, []string{"reader@email.com"}), []string{"dataset1", "dataset2"}, testOptions)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := &Plan{
+		Access: []AccessChange{
+			{Action: RemoveAction, Project: "bq-project", Dataset: "dataset1", Role: "READER", EntityType: "user", Entity: "reader@email.com"},
+		},
+	}
+	if !reflect.DeepEqual(plan, want) {
+		t.Errorf("got: %+v, want: %+v", plan, want)
+	}
+}
+
+func TestPlanWriteJSON(t *testing.T) {
+	cases := []struct {
+		name string
+		plan *Plan
+	}{
+		{name: "empty", plan: &Plan{}},
+		{name: "only dataset changes", plan: &Plan{Access: []AccessChange{
+			{Action: AddAction, Project: "bq-project", Dataset: "dataset1", Role: "READER", EntityType: "user", Entity: "reader@email.com"},
+		}}},
+		{name: "only project changes", plan: &Plan{Bindings: []BindingChange{
+			{Action: RemoveAction, Project: "bq-project", Role: "roles/bigquery.user", Member: "user:reader@email.com"},
+		}}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := c.plan.WriteJSON(&buf); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var got map[string]json.RawMessage
+			if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+				t.Fatalf("invalid JSON %q: %v", buf.String(), err)
+			}
+			for _, key := range []string{"bindings", "access"} {
+				if v := string(got[key]); v == "" || v == "null" {
+					t.Errorf("%s must be an array, got: %q", key, v)
+				}
+			}
+		})
+	}
+}
@@ -1,15 +1,10 @@
 package bqrole
 
 import (
-	"bufio"
 	"context"
-	"errors"
 	"fmt"
-	"os"
-	"os/exec"
-	"strings"
+	"slices"
 
-	bq "cloud.google.com/go/bigquery"
 	"github.com/rs/zerolog/log"
 )
 
@@ -24,156 +19,207 @@ func ProjectRole(role string) (string, error) {
 	return "", fmt.Errorf("failed to parse %s", role)
 }
 
-func PermitProject(role, project string, users []string, yes bool) error {
-	ctx := context.Background()
-	client, err := bq.NewClient(ctx, project)
-	if err != nil {
-		return errors.New("failed to create bigquery Client")
-	}
-	defer client.Close()
-
+func PermitProject(ctx context.Context, iam IAMBackend, role, project string, members []Member, opts Options) error {
 	fmt.Printf("PERMIT following PROJECT-WIDE permission\n")
 	fmt.Printf("project_id: %s\n", project)
 	fmt.Printf("role:       %s\n", role)
-	fmt.Printf("users:      %s\n", users)
-
-	if !yes {
-		fmt.Printf("If you proceeds, PROJECT-WIDE permission will be added. Are you sure? [y/n]")
+	fmt.Printf("users:      %s\n", members)
+	opts.printExpires()
 
-		reader := bufio.NewReader(os.Stdin)
-		res, err := reader.ReadString('\n')
-
-		if err != nil || strings.TrimSpace(res) != "y" {
-			fmt.Println("Abort.")
-			return nil
-		}
+	if !opts.Yes && !confirm("If you proceeds, PROJECT-WIDE permission will be added. Are you sure? [y/n]") {
+		fmt.Println("Abort.")
+		return nil
 	}
 
-	policy, err := FetchCurrentPolicy(project)
-	if err != nil {
-		return fmt.Errorf("failed to fetch current policy: %s", err)
-	}
+	return permitProject(ctx, iam, role, project, members, opts)
+}
 
+func permitProject(ctx context.Context, iam IAMBackend, role, project string, members []Member, opts Options) error {
 	// grant project-wide role if needed
-	for _, user := range users {
-		err = grantProjectRole(project, user, role, policy)
-		if err != nil {
-			return err
-		}
-		fmt.Printf("Permit %s to %s access as %s\n", user, project, role)
+	if err := grantProjectRoles(ctx, iam, project, members, []string{role}, opts); err != nil {
+		return err
+	}
+	for _, m := range members {
+		opts.printf("Permit %s to %s access as %s\n", m.ID, project, role)
 	}
 
 	return nil
 }
 
-func RevokeProject(role, project string, users []string, yes bool) error {
-	ctx := context.Background()
-	client, err := bq.NewClient(ctx, project)
-	if err != nil {
-		return errors.New("failed to create bigquery Client")
-	}
-	defer client.Close()
-
+func RevokeProject(ctx context.Context, iam IAMBackend, role, project string, members []Member, opts Options) error {
 	fmt.Printf("REVOKE following PROJECT-WIDE permission\n")
 	fmt.Printf("project_id: %s\n", project)
 	fmt.Printf("role:       %s\n", role)
-	fmt.Printf("users:      %s\n", users)
-
-	if !yes {
-		fmt.Printf("If you proceeds, PROJECT-WIDE permission will be added. Are you sure? [y/n]")
+	fmt.Printf("users:      %s\n", members)
 
-		reader := bufio.NewReader(os.Stdin)
-		res, err := reader.ReadString('\n')
-
-		if err != nil || strings.TrimSpace(res) != "y" {
-			fmt.Println("Abort.")
-			return nil
-		}
+	if !opts.Yes && !confirm("If you proceeds, PROJECT-WIDE permission will be removed. Are you sure? [y/n]") {
+		fmt.Println("Abort.")
+		return nil
 	}
 
-	policy, err := FetchCurrentPolicy(project)
-	if err != nil {
-		return fmt.Errorf("failed to fetch current policy: %s", err)
-	}
+	return revokeProject(ctx, iam, role, project, members, opts)
+}
 
+func revokeProject(ctx context.Context, iam IAMBackend, role, project string, members []Member, opts Options) error {
 	// revoke project-wide role if needed
-	for _, user := range users {
-		err = revokeProjectRole(project, user, role, policy)
-		if err != nil {
-			return err
-		}
-		fmt.Printf("Revoked %s's permission of %s access as %s\n", user, project, role)
+	if err := revokeProjectRoles(ctx, iam, project, members, role, opts); err != nil {
+		return err
+	}
+	for _, m := range members {
+		opts.printf("Revoked %s's permission of %s access as %s\n", m.ID, project, role)
 	}
 
 	return nil
 }
 
-func grantProjectRole(project, user, role string, policy *ProjectPolicy) error {
-	if hasProjectRole(policy, user, role) { // already has roles/viewer
-		log.Info().Msgf("%s already has a role: %s, project: %s. skipped.", user, role, project)
+// grantProjectRoles binds all the members to all the roles with a single policy update.
+func grantProjectRoles(ctx context.Context, iam IAMBackend, project string, members []Member, roles []string, opts Options) error {
+	err := updatePolicy(ctx, iam, opts.Retry, project, func(policy *ProjectPolicy) bool {
+		changed := false
+		for _, role := range roles {
+			for _, m := range members {
+				if hasProjectRole(policy, m, role) {
+					log.Info().Msgf("%s already has a role: %s, project: %s. skipped.", m.ID, role, project)
+					continue
+				}
+				changed = policy.bindMember(role, m.String(), opts.condition()) || changed
+			}
+		}
+		return changed
+	})
+	if err == nil {
 		return nil
 	}
+	if !isInvalidArgument(err) || !anyCanBeGroup(members, opts) {
+		return fmt.Errorf("failed to update policy bindings of %s: %s", project, err)
+	}
 
-	var member string
-	if isServiceAccount(user) {
-		member = "serviceAccount:" + user
-	} else {
-		member = "user:" + user
+	// some of the members may be group accounts, so bind them one by one
+	log.Warn().Msg("failed to update policy bindings at once, try binding members one by one")
+	for _, role := range roles {
+		for _, m := range members {
+			if err := bindMember(ctx, iam, project, m, role, opts); err != nil {
+				return err
+			}
+		}
 	}
+	return nil
+}
 
-	cmd := exec.Command("gcloud", "projects", "add-iam-policy-binding", project, "--member", member, "--role", role, "--condition=None")
-	out, err := cmd.CombinedOutput()
-	if !strings.Contains(string(out), "INVALID_ARGUMENT") {
-		if err != nil {
-			fmt.Fprintln(os.Stderr, string(out))
-			return fmt.Errorf("failed to update policy bindings to grant %s %s: %s", user, role, err)
+// revokeProjectRoles unbinds all the members from the role with a single policy update.
+func revokeProjectRoles(ctx context.Context, iam IAMBackend, project string, members []Member, role string, opts Options) error {
+	err := updatePolicy(ctx, iam, opts.Retry, project, func(policy *ProjectPolicy) bool {
+		changed := false
+		for _, m := range members {
+			removed := policy.removeMemberIf(role, m.String(), isRevocable)
+			if !removed && m.canBeGroup(opts) {
+				removed = policy.removeMemberIf(role, m.asGroup().String(), isRevocable)
+			}
+			if !removed {
+				log.Info().Msgf("%s doesn't have a role: %s, project: %s. skipped.", m.ID, role, project)
+				continue
+			}
+			changed = true
 		}
+		return changed
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update policy bindings to revoke %s %s: %s", members, role, err)
+	}
+	return nil
+}
+
+// bindMember binds m to role on the project.
+// If IAM rejects a guessed user account, bindMember retries binding it as a group account.
+func bindMember(ctx context.Context, iam IAMBackend, project string, m Member, role string, opts Options) error {
+	err := addPolicyBinding(ctx, iam, opts.Retry, project, m.String(), role, opts.condition())
+	if err == nil {
 		return nil
 	}
+	if !isInvalidArgument(err) || !m.canBeGroup(opts) {
+		return fmt.Errorf("failed to update policy bindings to grant %s %s: %s", m, role, err)
+	}
 
 	// try to bind to "group" account
-	log.Warn().Msg("failed to permit as user account, try group account")
-	member = "group:" + user
-	cmd = exec.Command("gcloud", "projects", "add-iam-policy-binding", project, "--member", member, "--role", role, "--condition=None")
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to update policy bindings to grant %s %s: %s", user, role, err)
+	log.Warn().Msgf("failed to permit %s as user account, try group account", m.ID)
+	if err := addPolicyBinding(ctx, iam, opts.Retry, project, m.asGroup().String(), role, opts.condition()); err != nil {
+		return fmt.Errorf("failed to update policy bindings to grant %s %s: %s", m.asGroup(), role, err)
 	}
 
 	return nil
 }
 
-func revokeProjectRole(project, user, role string, policy *ProjectPolicy) error {
-	if !hasProjectRole(policy, user, role) {
-		log.Info().Msgf("%s doesn't have a role: %s, project: %s. skipped.", user, role, project)
-		return nil
-	}
+// addPolicyBinding adds member to role with the condition if any, like `gcloud projects add-iam-policy-binding`.
+func addPolicyBinding(ctx context.Context, iam IAMBackend, retry RetryPolicy, project, member, role string, condition *Condition) error {
+	return updatePolicy(ctx, iam, retry, project, func(policy *ProjectPolicy) bool {
+		return policy.bindMember(role, member, condition)
+	})
+}
 
-	var member string
-	if isServiceAccount(user) {
-		member = "serviceAccount:" + user
-	} else {
-		member = "user:" + user
+// bindMember adds member to role with the condition if any.
+// A time-bound binding replaces an earlier deadline of the member, and is skipped if the member already has
+// the role without a deadline or until a later one, e.g. granted for another dataset.
+func (p *ProjectPolicy) bindMember(role, member string, condition *Condition) bool {
+	changed := false
+	if isExpiryCondition(condition) {
+		if p.boundLonger(role, member, condition) {
+			return false
+		}
+		changed = p.removeMemberIf(role, member, func(c *Condition) bool {
+			return isExpiryCondition(c) && !sameCondition(c, condition)
+		})
 	}
+	return p.addConditionalMember(role, member, condition) || changed
+}
 
-	cmd := exec.Command("gcloud", "projects", "remove-iam-policy-binding", project, "--member", member, "--role", role)
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to update policy bindings to revoke %s %s: %s\n%s", user, role, err, err.(*exec.ExitError).Stderr)
+// boundLonger reports whether member has role without a condition or until the deadline of the condition or later.
+func (p *ProjectPolicy) boundLonger(role, member string, condition *Condition) bool {
+	expires, ok := conditionExpiry(condition)
+	if !ok {
+		return false
+	}
+	for _, b := range p.Bindings {
+		if b.Role != role || !slices.Contains(b.Members, member) {
+			continue
+		}
+		if b.Condition == nil {
+			return true
+		}
+		if !isExpiryCondition(b.Condition) {
+			continue
+		}
+		if current, ok := conditionExpiry(b.Condition); !ok || !current.Before(expires) {
+			return true // an unknown deadline is kept as is
+		}
 	}
+	return false
+}
 
-	return nil
+// isRevocable reports whether revoke removes the binding with the condition:
+// unconditional bindings and the time-bound bindings made by bqiam.
+func isRevocable(c *Condition) bool {
+	return c == nil || isExpiryCondition(c)
 }
 
-func hasProjectRole(p *ProjectPolicy, user, role string) bool {
+func hasProjectRole(p *ProjectPolicy, m Member, role string) bool {
 	for _, b := range p.Bindings {
-		if b.Role == role {
-			for _, m := range b.Members {
-				if strings.HasSuffix(m, user) { // format of m is (user|service):[user-email]
-					return true
-				}
+		if b.Role != role || b.Condition != nil {
+			continue
+		}
+		for _, member := range b.Members {
+			if m.matches(member) {
+				return true
 			}
 		}
 	}
 	return false
 }
+
+func anyCanBeGroup(members []Member, opts Options) bool {
+	for _, m := range members {
+		if m.canBeGroup(opts) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package bqrole
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// testOptions runs permit/revoke without prompts nor waits between retries.
+var testOptions = Options{Yes: true, Retry: RetryPolicy{MaxAttempts: 3}, DetectMemberType: true}
+
+func mustParseMembers(t *testing.T, ss []string) []Member {
+	t.Helper()
+	members, err := ParseMembers(ss)
+	if err != nil {
+		t.Fatalf("failed to parse members: %v", err)
+	}
+	return members
+}
+
+func TestPermitProject(t *testing.T) {
+	cases := []struct {
+		name     string
+		users    []string
+		want     []string
+		wantSets int
+	}{
+		{
+			name:     "new user",
+			users:    []string{"user1@email.com"},
+			want:     []string{"user:owner@email.com", "user:user1@email.com"},
+			wantSets: 1,
+		},
+		{
+			name:     "multiple users",
+			users:    []string{"user1@email.com", "user2@email.com"},
+			want:     []string{"user:owner@email.com", "user:user1@email.com", "user:user2@email.com"},
+			wantSets: 1,
+		},
+		{
+			name:     "service account",
+			users:    []string{"sa@bq-project.iam.gserviceaccount.com"},
+			want:     []string{"user:owner@email.com", "serviceAccount:sa@bq-project.iam.gserviceaccount.com"},
+			wantSets: 1,
+		},
+		{
+			name:     "similar email",
+			users:    []string{"er@email.com"},
+			want:     []string{"user:owner@email.com", "user:er@email.com"},
+			wantSets: 1,
+		},
+		{
+			name:     "already granted",
+			users:    []string{"owner@email.com"},
+			want:     []string{"user:owner@email.com"},
+			wantSets: 0,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			iam := NewFakeIAMBackend(map[string]*ProjectPolicy{
+				"bq-project": {Bindings: []Binding{{Role: "roles/viewer", Members: []string{"user:owner@email.com"}}}},
+			})
+
+			if err := PermitProject(ctx, iam, "roles/viewer", "bq-project", mustParseMembers(t, c.users), testOptions); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			policy, _ := iam.GetPolicy(ctx, "bq-project")
+			if got := policy.Bindings[0].Members; !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got: %v, want: %v", got, c.want)
+			}
+			if got := iam.SetPolicyCalls(); got != c.wantSets {
+				t.Errorf("setIamPolicy calls got: %d, want: %d", got, c.wantSets)
+			}
+		})
+	}
+}
+
+// racyIAMBackend modifies the policy behind the caller's back before the first write.
+type racyIAMBackend struct {
+	*FakeIAMBackend
+	raced bool
+}
+
+func (r *racyIAMBackend) SetPolicy(ctx context.Context, project string, policy *ProjectPolicy) (*ProjectPolicy, error) {
+	if !r.raced {
+		r.raced = true
+		if err := addPolicyBinding(ctx, r.FakeIAMBackend, testOptions.Retry, project, "user:other@email.com", "roles/viewer", nil); err != nil {
+			return nil, err
+		}
+	}
+	return r.FakeIAMBackend.SetPolicy(ctx, project, policy)
+}
+
+func TestPermitProjectConflict(t *testing.T) {
+	ctx := context.Background()
+	iam := &racyIAMBackend{FakeIAMBackend: NewFakeIAMBackend(map[string]*ProjectPolicy{"bq-project": {}})}
+
+	if err := PermitProject(ctx, iam, "roles/viewer", "bq-project", mustParseMembers(t, []string{"user1@email.com"}), testOptions); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	policy, _ := iam.GetPolicy(ctx, "bq-project")
+	want := []Binding{{Role: "roles/viewer", Members: []string{"user:other@email.com", "user:user1@email.com"}}}
+	if !reflect.DeepEqual(policy.Bindings, want) {
+		t.Errorf("got: %v, want: %v", policy.Bindings, want)
+	}
+}
+
+func TestRevokeProject(t *testing.T) {
+	cases := []struct {
+		name  string
+		users []string
+		want  []Binding
+	}{
+		{
+			name:  "revoke one of members",
+			users: []string{"user1@email.com"},
+			want:  []Binding{{Role: "roles/viewer", Members: []string{"user:user2@email.com"}}},
+		},
+		{
+			name:  "revoke all members",
+			users: []string{"user1@email.com", "user2@email.com"},
+			want:  nil,
+		},
+		{
+			name:  "not granted",
+			users: []string{"user3@email.com"},
+			want:  []Binding{{Role: "roles/viewer", Members: []string{"user:user1@email.com", "user:user2@email.com"}}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			iam := NewFakeIAMBackend(map[string]*ProjectPolicy{
+				"bq-project": {Bindings: []Binding{{Role: "roles/viewer", Members: []string{"user:user1@email.com", "user:user2@email.com"}}}},
+			})
+
+			if err := RevokeProject(ctx, iam, "roles/viewer", "bq-project", mustParseMembers(t, c.users), testOptions); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			policy, _ := iam.GetPolicy(ctx, "bq-project")
+			if !reflect.DeepEqual(policy.Bindings, c.want) {
+				t.Errorf("got: %v, want: %v", policy.Bindings, c.want)
+			}
+		})
+	}
+}
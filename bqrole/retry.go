@@ -0,0 +1,45 @@
+package bqrole
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// RetryPolicy controls how updates conflicting with concurrent modifications are retried.
+type RetryPolicy struct {
+	MaxAttempts    int           // total number of attempts including the first one
+	InitialBackoff time.Duration // wait before the first retry, doubled on each retry
+	MaxBackoff     time.Duration // upper bound of the wait
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     8 * time.Second,
+}
+
+// Do calls fn until it succeeds, fails with an error retryable doesn't accept,
+// or MaxAttempts is reached. Waits between attempts grow exponentially.
+func (p RetryPolicy) Do(ctx context.Context, retryable func(error) bool, fn func() error) error {
+	backoff := p.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !retryable(err) || attempt >= p.MaxAttempts {
+			return err
+		}
+
+		log.Warn().Msgf("%s, retrying in %s (%d/%d)", err, backoff, attempt, p.MaxAttempts)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
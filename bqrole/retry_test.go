@@ -0,0 +1,64 @@
+package bqrole
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRetryPolicyDo(t *testing.T) {
+	errRetryable := errors.New("retryable")
+	errFatal := errors.New("fatal")
+
+	cases := []struct {
+		name         string
+		errs         []error // errors returned by each attempt
+		wantErr      error
+		wantAttempts int
+	}{
+		{
+			name:         "success",
+			errs:         []error{nil},
+			wantErr:      nil,
+			wantAttempts: 1,
+		},
+		{
+			name:         "success after retry",
+			errs:         []error{errRetryable, errRetryable, nil},
+			wantErr:      nil,
+			wantAttempts: 3,
+		},
+		{
+			name:         "not retryable",
+			errs:         []error{errFatal},
+			wantErr:      errFatal,
+			wantAttempts: 1,
+		},
+		{
+			name:         "attempts exhausted",
+			errs:         []error{errRetryable, errRetryable, errRetryable, nil},
+			wantErr:      errRetryable,
+			wantAttempts: 3,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			attempts := 0
+			err := RetryPolicy{MaxAttempts: 3}.Do(context.Background(),
+				func(err error) bool { return errors.Is(err, errRetryable) },
+				func() error {
+					err := c.errs[attempts]
+					attempts++
+					return err
+				})
+
+			if !errors.Is(err, c.wantErr) {
+				t.Errorf("got: %v, want: %v", err, c.wantErr)
+			}
+			if attempts != c.wantAttempts {
+				t.Errorf("attempts got: %d, want: %d", attempts, c.wantAttempts)
+			}
+		})
+	}
+}
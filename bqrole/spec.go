@@ -0,0 +1,302 @@
+package bqrole
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	bq "cloud.google.com/go/bigquery"
+	"gopkg.in/yaml.v3"
+)
+
+// Spec declares which members get which project and dataset roles, like:
+//
+//	projects:
+//	  - project: bq-project-id
+//	    roles:
+//	      - role: roles/bigquery.jobUser
+//	        members: [user:user1@email.com, group:group1@email.com]
+//	    datasets:
+//	      - dataset: dataset1
+//	        access:
+//	          - role: READER
+//	            members: [user:user1@email.com, group:group1@email.com]
+type Spec struct {
+	Projects []ProjectSpec `yaml:"projects"`
+}
+
+type ProjectSpec struct {
+	Project  string        `yaml:"project"`
+	Roles    []RoleSpec    `yaml:"roles"`
+	Datasets []DatasetSpec `yaml:"datasets"`
+}
+
+type DatasetSpec struct {
+	Dataset string     `yaml:"dataset"`
+	Access  []RoleSpec `yaml:"access"`
+}
+
+type RoleSpec struct {
+	Role    string   `yaml:"role"`
+	Members []string `yaml:"members"`
+}
+
+// LoadSpec reads and validates the access policy file.
+func LoadSpec(file string) (*Spec, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read access policy file: %s", err)
+	}
+
+	var spec Spec
+	if err := yaml.Unmarshal(b, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse access policy file %s: %s", file, err)
+	}
+
+	if _, err := spec.resolve(); err != nil {
+		return nil, fmt.Errorf("invalid access policy file %s: %s", file, err)
+	}
+	return &spec, nil
+}
+
+// grant is a role given to a member, either on a project or on a dataset.
+type grant struct {
+	role   string
+	member Member
+}
+
+type resolvedProject struct {
+	project  string
+	roles    []grant
+	datasets map[string][]grant
+	order    []string // datasets in the declared order
+}
+
+// resolve parses members and roles of the spec.
+func (s *Spec) resolve() ([]resolvedProject, error) {
+	var projects []resolvedProject
+	for _, ps := range s.Projects {
+		if ps.Project == "" {
+			return nil, fmt.Errorf("project is required")
+		}
+		rp := resolvedProject{project: ps.Project, datasets: map[string][]grant{}}
+
+		grants, err := resolveRoles(ps.Roles, projectRole)
+		if err != nil {
+			return nil, fmt.Errorf("project %s: %s", ps.Project, err)
+		}
+		rp.roles = grants
+
+		for _, ds := range ps.Datasets {
+			if ds.Dataset == "" {
+				return nil, fmt.Errorf("project %s: dataset is required", ps.Project)
+			}
+			grants, err := resolveRoles(ds.Access, func(role string) (string, error) {
+				r, err := DatasetRole(role)
+				return string(r), err
+			})
+			if err != nil {
+				return nil, fmt.Errorf("dataset %s.%s: %s", ps.Project, ds.Dataset, err)
+			}
+			if _, ok := rp.datasets[ds.Dataset]; !ok {
+				rp.order = append(rp.order, ds.Dataset)
+			}
+			rp.datasets[ds.Dataset] = append(rp.datasets[ds.Dataset], grants...)
+		}
+		projects = append(projects, rp)
+	}
+	return projects, nil
+}
+
+// projectRole checks the project role is a predefined role like roles/bigquery.jobUser
+// or a custom one like projects/bq-project-id/roles/customRole.
+func projectRole(role string) (string, error) {
+	if strings.HasPrefix(role, "roles/") {
+		return role, nil
+	}
+	if (strings.HasPrefix(role, "projects/") || strings.HasPrefix(role, "organizations/")) && strings.Contains(role, "/roles/") {
+		return role, nil
+	}
+	return "", fmt.Errorf("invalid project role %s (must be like roles/bigquery.jobUser)", role)
+}
+
+func resolveRoles(specs []RoleSpec, parseRole func(string) (string, error)) ([]grant, error) {
+	var grants []grant
+	for _, rs := range specs {
+		role, err := parseRole(rs.Role)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range rs.Members {
+			m, err := ParseMember(s)
+			if err != nil {
+				return nil, err
+			}
+			grants = append(grants, grant{role: role, member: m})
+		}
+	}
+	return grants, nil
+}
+
+// PlanSpec returns the changes ApplySpec would make without making them.
+func PlanSpec(ctx context.Context, iam IAMBackend, acl DatasetBackend, spec *Spec, prune bool, opts Options) (*Plan, error) {
+	plan := &Plan{}
+	err := applySpec(ctx, newPlanIAMBackend(iam, plan), newPlanDatasetBackend(acl, plan), spec, prune, opts.forPlan())
+	return plan, err
+}
+
+// ApplySpec adds the project bindings and dataset access entries declared in spec but missing in the live state.
+// With prune, it also removes the members of the declared project roles and the principals
+// on the declared datasets that spec doesn't list.
+// Special groups, authorized views, routines and datasets are never pruned.
+func ApplySpec(ctx context.Context, iam IAMBackend, acl DatasetBackend, spec *Spec, prune bool, opts Options) error {
+	plan, err := PlanSpec(ctx, iam, acl, spec, prune, opts)
+	if err != nil {
+		return fmt.Errorf("failed to plan: %s", err)
+	}
+
+	plan.Print(os.Stdout)
+	if plan.IsEmpty() {
+		return nil
+	}
+
+	if !opts.Yes && !confirm("Are you sure? [y/n]") {
+		fmt.Println("Abort.")
+		return nil
+	}
+
+	return applySpec(ctx, iam, acl, spec, prune, opts)
+}
+
+func applySpec(ctx context.Context, iam IAMBackend, acl DatasetBackend, spec *Spec, prune bool, opts Options) error {
+	projects, err := spec.resolve()
+	if err != nil {
+		return err
+	}
+
+	for _, rp := range projects {
+		if len(rp.roles) > 0 {
+			var changed bool
+			err := updatePolicy(ctx, iam, opts.Retry, rp.project, func(policy *ProjectPolicy) bool {
+				changed = reconcilePolicy(policy, rp.roles, prune)
+				return changed
+			})
+			if err != nil {
+				return fmt.Errorf("failed to update policy bindings of %s: %s", rp.project, err)
+			}
+			if changed {
+				opts.printf("Updated policy bindings of project %s\n", rp.project)
+			}
+		}
+
+		for _, dataset := range rp.order {
+			var changed bool
+			err := updateAccess(ctx, acl, opts.Retry, rp.project, dataset, func(access *DatasetAccess) bool {
+				changed = reconcileAccess(access, rp.datasets[dataset], prune)
+				return changed
+			})
+			if err != nil {
+				return fmt.Errorf("failed to update access of %s.%s: %s", rp.project, dataset, err)
+			}
+			if changed {
+				opts.printf("Updated access of dataset %s.%s\n", rp.project, dataset)
+			}
+		}
+	}
+	return nil
+}
+
+// reconcilePolicy makes the unconditional bindings of the declared roles match grants.
+func reconcilePolicy(policy *ProjectPolicy, grants []grant, prune bool) bool {
+	changed := false
+	for _, g := range grants {
+		if hasProjectRole(policy, g.member, g.role) {
+			continue
+		}
+		changed = policy.addMember(g.role, g.member.String()) || changed
+	}
+	if !prune {
+		return changed
+	}
+
+	declared := map[string]bool{}
+	for _, g := range grants {
+		declared[g.role] = true
+	}
+	for _, b := range policy.Bindings {
+		if !declared[b.Role] || b.Condition != nil {
+			continue
+		}
+		for _, member := range b.Members {
+			if !isGranted(grants, b.Role, member) {
+				changed = policy.removeMember(b.Role, member) || changed
+			}
+		}
+	}
+	return changed
+}
+
+func isGranted(grants []grant, role, member string) bool {
+	for _, g := range grants {
+		if g.role == role && g.member.matches(member) {
+			return true
+		}
+	}
+	return false
+}
+
+// reconcileAccess makes the principal entries of the dataset access list match grants.
+func reconcileAccess(access *DatasetAccess, grants []grant, prune bool) bool {
+	changed := false
+	var entries []*bq.AccessEntry
+	for _, e := range access.Entries {
+		if prune && isPrincipal(e) && !isGrantedEntry(grants, e) {
+			changed = true
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	for _, g := range grants {
+		if isGrantedTo(entries, g) {
+			continue
+		}
+		entries = append(entries, &bq.AccessEntry{
+			Role:       bq.AccessRole(g.role),
+			EntityType: g.member.EntityType(),
+			Entity:     g.member.ID,
+		})
+		changed = true
+	}
+
+	access.Entries = entries
+	return changed
+}
+
+func isGrantedEntry(grants []grant, e *bq.AccessEntry) bool {
+	for _, g := range grants {
+		if string(e.Role) == g.role && g.member.matchesEntry(e) {
+			return true
+		}
+	}
+	return false
+}
+
+func isGrantedTo(entries []*bq.AccessEntry, g grant) bool {
+	for _, e := range entries {
+		if string(e.Role) == g.role && g.member.matchesEntry(e) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPrincipal reports whether the access entry is granted to an account or a domain.
+func isPrincipal(e *bq.AccessEntry) bool {
+	switch e.EntityType {
+	case bq.UserEmailEntity, bq.GroupEmailEntity, bq.DomainEntity, bq.IAMMemberEntity:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,244 @@
+package bqrole
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	bq "cloud.google.com/go/bigquery"
+)
+
+func TestLoadSpec(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			content: `projects:
+  - project: bq-project
+    roles:
+      - role: roles/bigquery.jobUser
+        members: [user:reader@email.com]
+    datasets:
+      - dataset: dataset1
+        access:
+          - role: READER
+            members: [reader@email.com, group:group@email.com]
+`,
+		},
+		{
+			name: "unknown dataset role",
+			content: `projects:
+  - project: bq-project
+    datasets:
+      - dataset: dataset1
+        access:
+          - role: VIEWER
+            members: [reader@email.com]
+`,
+			wantErr: true,
+		},
+		{
+			name: "unknown member type",
+			content: `projects:
+  - project: bq-project
+    roles:
+      - role: roles/bigquery.jobUser
+        members: [team:reader@email.com]
+`,
+			wantErr: true,
+		},
+		{
+			name: "project role without roles/ prefix",
+			content: `projects:
+  - project: bq-project
+    roles:
+      - role: READER
+        members: [user:reader@email.com]
+`,
+			wantErr: true,
+		},
+		{
+			name: "custom project role",
+			content: `projects:
+  - project: bq-project
+    roles:
+      - role: projects/bq-project/roles/customReader
+        members: [user:reader@email.com]
+`,
+		},
+		{
+			name: "missing project",
+			content: `projects:
+  - roles:
+      - role: roles/bigquery.jobUser
+        members: [user:reader@email.com]
+`,
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "access.yaml")
+			if err := os.WriteFile(file, []byte(c.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := LoadSpec(file)
+			if (err != nil) != c.wantErr {
+				t.Errorf("got err: %v, wantErr: %v", err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestPlanSpec(t *testing.T) {
+	spec := &Spec{Projects: []ProjectSpec{{
+		Project: "bq-project",
+		Roles: []RoleSpec{
+			{Role: "roles/bigquery.jobUser", Members: []string{"user:reader@email.com", "group:group@email.com"}},
+		},
+		Datasets: []DatasetSpec{{
+			Dataset: "dataset1",
+			Access: []RoleSpec{
+				{Role: "READER", Members: []string{"user:reader@email.com", "group:group@email.com"}},
+			},
+		}},
+	}}}
+
+	cases := []struct {
+		name  string
+		prune bool
+		want  *Plan
+	}{
+		{
+			name:  "add only",
+			prune: false,
+			want: &Plan{
+				Bindings: []BindingChange{
+					{Action: AddAction, Project: "bq-project", Role: "roles/bigquery.jobUser", Member: "group:group@email.com"},
+				},
+				Access: []AccessChange{
+					{Action: AddAction, Project: "bq-project", Dataset: "dataset1", Role: "READER", EntityType: "group", Entity: "group@email.com"},
+				},
+			},
+		},
+		{
+			name:  "prune",
+			prune: true,
+			want: &Plan{
+				Bindings: []BindingChange{
+					{Action: AddAction, Project: "bq-project", Role: "roles/bigquery.jobUser", Member: "group:group@email.com"},
+					{Action: RemoveAction, Project: "bq-project", Role: "roles/bigquery.jobUser", Member: "user:other@email.com"},
+				},
+				Access: []AccessChange{
+					{Action: AddAction, Project: "bq-project", Dataset: "dataset1", Role: "READER", EntityType: "group", Entity: "group@email.com"},
+					{Action: RemoveAction, Project: "bq-project", Dataset: "dataset1", Role: "WRITER", EntityType: "user", Entity: "reader@email.com"},
+					{Action: RemoveAction, Project: "bq-project", Dataset: "dataset1", Role: "READER", EntityType: "user", Entity: "other@email.com"},
+				},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			iam := NewFakeIAMBackend(map[string]*ProjectPolicy{
+				"bq-project": {Bindings: []Binding{
+					{Role: "roles/bigquery.jobUser", Members: []string{"user:reader@email.com", "user:other@email.com"}},
+					{Role: "roles/bigquery.user", Members: []string{"user:other@email.com"}},
+					{
+						Role:      "roles/bigquery.jobUser",
+						Members:   []string{"user:temporary@email.com"},
+						Condition: &Condition{Expression: `request.time < timestamp("2030-01-01T00:00:00Z")`},
+					},
+				}},
+			})
+			acl := NewFakeDatasetBackend(map[string][]*bq.AccessEntry{
+				"bq-project.dataset1": {
+					{Role: bq.ReaderRole, EntityType: bq.UserEmailEntity, Entity: "reader@email.com"},
+					{Role: bq.WriterRole, EntityType: bq.UserEmailEntity, Entity: "reader@email.com"},
+					{Role: bq.ReaderRole, EntityType: bq.UserEmailEntity, Entity: "other@email.com"},
+					{Role: bq.OwnerRole, EntityType: bq.SpecialGroupEntity, Entity: "projectOwners"},
+				},
+			})
+
+			plan, err := PlanSpec(ctx, iam, acl, spec, c.prune, testOptions)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(plan, c.want) {
+				t.Errorf("got: %+v, want: %+v", plan, c.want)
+			}
+			if iam.SetPolicyCalls() != 0 || acl.SetAccessCalls() != 0 {
+				t.Errorf("plan must not write: setIamPolicy %d, dataset update %d", iam.SetPolicyCalls(), acl.SetAccessCalls())
+			}
+		})
+	}
+}
+
+func TestPlanSpecGuessedMember(t *testing.T) {
+	// a bare email in the spec matches the live member of any type, like permit does
+	spec := &Spec{Projects: []ProjectSpec{{
+		Project: "bq-project",
+		Roles: []RoleSpec{
+			{Role: "roles/bigquery.jobUser", Members: []string{"team@email.com"}},
+		},
+	}}}
+
+	ctx := context.Background()
+	iam := NewFakeIAMBackend(map[string]*ProjectPolicy{
+		"bq-project": {Bindings: []Binding{
+			{Role: "roles/bigquery.jobUser", Members: []string{"group:team@email.com", "user:other@email.com"}},
+		}},
+	})
+
+	plan, err := PlanSpec(ctx, iam, NewFakeDatasetBackend(nil), spec, true, testOptions)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := &Plan{Bindings: []BindingChange{
+		{Action: RemoveAction, Project: "bq-project", Role: "roles/bigquery.jobUser", Member: "user:other@email.com"},
+	}}
+	if !reflect.DeepEqual(plan, want) {
+		t.Errorf("got: %+v, want: %+v", plan, want)
+	}
+}
+
+func TestApplySpec(t *testing.T) {
+	ctx := context.Background()
+	spec := &Spec{Projects: []ProjectSpec{{
+		Project: "bq-project",
+		Roles: []RoleSpec{
+			{Role: "roles/bigquery.jobUser", Members: []string{"user:reader@email.com"}},
+		},
+		Datasets: []DatasetSpec{{
+			Dataset: "dataset1",
+			Access:  []RoleSpec{{Role: "READER", Members: []string{"user:reader@email.com"}}},
+		}},
+	}}}
+	iam := NewFakeIAMBackend(map[string]*ProjectPolicy{"bq-project": {}})
+	acl := NewFakeDatasetBackend(map[string][]*bq.AccessEntry{"bq-project.dataset1": nil})
+
+	if err := ApplySpec(ctx, iam, acl, spec, false, testOptions); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if iam.SetPolicyCalls() != 1 || acl.SetAccessCalls() != 1 {
+		t.Errorf("got setIamPolicy %d, dataset update %d, want 1 each", iam.SetPolicyCalls(), acl.SetAccessCalls())
+	}
+
+	// applying again is a no-op
+	plan, err := PlanSpec(ctx, iam, acl, spec, false, testOptions)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !plan.IsEmpty() {
+		t.Errorf("got: %+v, want no changes", plan)
+	}
+}
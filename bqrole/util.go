@@ -1,43 +1,76 @@
 package bqrole
 
 import (
-	"encoding/json"
+	"bufio"
 	"fmt"
-	"os/exec"
+	"os"
 	"strings"
-
-	"github.com/rs/zerolog/log"
+	"time"
 )
 
-type ProjectPolicy struct {
-	Bindings []struct {
-		Role    string   `json:"role"`
-		Members []string `json:"members"`
-	} `json:"bindings"`
-	Etag    string `json:"etag"`
-	Version int    `json:"version"`
+// Options controls how permit and revoke run.
+type Options struct {
+	Yes   bool        // automatic yes to prompts
+	Retry RetryPolicy // retry policy on concurrent modifications
+
+	// DetectMemberType retries members given as bare emails as group accounts
+	// when they are rejected as user accounts.
+	DetectMemberType bool
+
+	// Expires makes the grants time-bound if set: project roles are bound with an IAM condition,
+	// and dataset roles are recorded in Ledger to be revoked by Expire.
+	// Without Expires, the dataset roles granted permanently are removed from Ledger if set, so that Expire keeps them.
+	Expires time.Time
+	Ledger  *Ledger
+
+	quiet bool // suppress the progress output, set while planning
 }
 
-func FetchCurrentPolicy(project string) (*ProjectPolicy, error) {
-	cmd := exec.Command("gcloud", "projects", "get-iam-policy", project, "--format=json")
-	log.Info().Msg(fmt.Sprintf("execute: %s", cmd.String()))
+// forPlan returns the options to compute a plan: no prompts and no progress output.
+func (o Options) forPlan() Options {
+	o.Yes, o.quiet = true, true
+	o.Ledger = nil // nothing is granted to record
+	return o
+}
 
-	policyJson, err := cmd.Output()
-	if err != nil {
-		return nil, fmt.Errorf("failed to run gcloud command to get current iam policy: %s\n%s", err, err.(*exec.ExitError).Stderr)
+// condition returns the IAM condition of the project roles to grant, nil for permanent grants.
+func (o Options) condition() *Condition {
+	if o.Expires.IsZero() {
+		return nil
 	}
+	return expiryCondition(o.Expires)
+}
 
-	log.Info().Msg(fmt.Sprintf("finish: %s", cmd))
+func (o Options) printExpires() {
+	if !o.Expires.IsZero() {
+		fmt.Printf("expires:    %s\n", o.Expires.Local().Format(time.DateTime))
+	}
+}
 
-	var policy ProjectPolicy
-	err = json.Unmarshal(policyJson, &policy)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal policy json: error: %s", err)
+func (o Options) printf(format string, a ...any) {
+	if !o.quiet {
+		fmt.Printf(format, a...)
 	}
+}
+
+// confirm prints msg and reports whether the user answered "y".
+func confirm(msg string) bool {
+	fmt.Print(msg)
+
+	reader := bufio.NewReader(os.Stdin)
+	res, err := reader.ReadString('\n')
 
-	return &policy, nil
+	return err == nil && strings.TrimSpace(res) == "y"
 }
 
 func isServiceAccount(user string) bool {
 	return strings.HasSuffix(user, "iam.gserviceaccount.com")
 }
+
+// memberID returns the email or domain part of an IAM member.
+func memberID(member string) string {
+	if i := strings.Index(member, ":"); i >= 0 {
+		return member[i+1:]
+	}
+	return member
+}
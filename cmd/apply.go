@@ -0,0 +1,79 @@
+/*
+Copyright © 2020 Hirohito Sasakawa
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hirosassa/bqiam/bqrole"
+)
+
+func init() {
+	rootCmd.AddCommand(newApplyCmd())
+}
+
+func newApplyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "apply -f [access policy file (required)]",
+		Short: "makes the access match the access policy file",
+		Long: `apply adds the project roles and dataset access declared in the access policy file but missing,
+and with --prune removes the ones not declared. It shows the plan and asks before applying.
+For example:
+
+bqiam apply -f access.yaml
+bqiam apply -f access.yaml --prune -y`,
+		RunE: runApplyCmd,
+	}
+
+	cmd.Flags().StringP("file", "f", "", "Specify access policy file")
+	err := cmd.MarkFlagRequired("file")
+	if err != nil {
+		panic(err)
+	}
+
+	cmd.Flags().Bool("prune", false, "Also remove members of the declared roles and datasets that are not in the file")
+	cmd.Flags().BoolP("yes", "y", false, "Automatic yes to prompts")
+
+	return cmd
+}
+
+func runApplyCmd(cmd *cobra.Command, args []string) error {
+	spec, prune, err := parseSpecFlags(cmd)
+	if err != nil {
+		return err
+	}
+
+	yes, err := cmd.Flags().GetBool("yes")
+	if err != nil {
+		return fmt.Errorf("failed to parse yes flag: %s", err)
+	}
+
+	ctx := context.Background()
+	iam, acl, closeFn, err := newSpecBackends(ctx, spec)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	err = bqrole.ApplySpec(ctx, iam, acl, spec, prune, bqrole.Options{Yes: yes, Retry: config.UpdateRetry})
+	if err != nil {
+		return fmt.Errorf("failed to apply: %s", err)
+	}
+	return nil
+}
@@ -19,16 +19,24 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
+	"os/signal"
+	"strings"
 	"sync"
+	"time"
 
 	bq "cloud.google.com/go/bigquery"
 
+	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 	mpb "github.com/vbauerster/mpb/v8"
 	decor "github.com/vbauerster/mpb/v8/decor"
+	"golang.org/x/sync/errgroup"
 	"google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
 
+	"github.com/hirosassa/bqiam/bqrole"
 	"github.com/hirosassa/bqiam/metadata"
 )
 
@@ -41,73 +49,317 @@ var cacheCmd = &cobra.Command{
 }
 
 func runCmdCache(cmd *cobra.Command, args []string) error {
-	ctx := context.Background()
-	var metas metadata.Metas
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// the flags are not defined when the cache is refreshed from other commands
+	full, _ := cmd.Flags().GetBool("full")
+	allowPartial, _ := cmd.Flags().GetBool("allow-partial")
+
+	// reuse the access entries of the datasets not modified since the last run
+	var prev metadata.Metas
+	if !full {
+		if err := readCache(&prev); err != nil {
+			log.Info().Msgf("refresh all datasets: %s", err)
+		}
+	}
+	cached := cachedDatasets(prev)
+
+	service, err := bigquery.NewService(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create bigquery service: %s", err)
+	}
 
 	projects, err := listProjects(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to fetch GCP projects: %s", err)
 	}
 
-	fatalErrors := make(chan error)
-	wgDone := make(chan bool)
+	iam, err := bqrole.NewResourceManagerBackend(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create IAM client: %s", err)
+	}
 
-	var mutex sync.Mutex
-	var wg sync.WaitGroup
-	pb := mpb.NewWithContext(ctx, mpb.WithWidth(32), mpb.WithWaitGroup(&wg))
+	f := newFetcher(config.CacheQPS, config.CacheRetry)
+	concurrency := max(config.CacheConcurrency, 1)
 
+	// A failure of a project stops only the rest of the project, and is reported after all projects are done.
+	results := make([]*projectResult, len(*projects))
+	clients := make([]*bq.Client, len(*projects))
 	for i, p := range *projects {
-		i := i
-		p := p
-
-		ds, err := listDataSets(ctx, p)
+		results[i] = &projectResult{project: p}
+		client, err := bq.NewClient(ctx, p)
 		if err != nil {
-			return fmt.Errorf("failed to fetch BigQuery datasets: project %s, error %s", p, err)
+			results[i].fail(fmt.Errorf("failed to create bigquery Client: %s", err))
+			continue
 		}
+		defer client.Close()
+		clients[i] = client
+	}
 
-		bar := NewBar(pb, int64(len(*ds)), fmt.Sprintf("[%v/%v][%v] caching datasets...", i+1, len(*projects), p))
+	// list the datasets of all projects
+	datasets := make([][]string, len(*projects))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(concurrency)
+	for i := range *projects {
+		r := results[i]
+		if r.failed() {
+			continue
+		}
+		g.Go(func() error {
+			err := f.do(gctx, func() error {
+				ds, err := listDataSets(gctx, clients[i])
+				datasets[i] = ds
+				return err
+			})
+			if err != nil {
+				r.fail(fmt.Errorf("failed to fetch BigQuery datasets: %s", err))
+			}
+			return nil
+		})
+	}
+	_ = g.Wait() // failures are recorded to results
+
+	pb := mpb.NewWithContext(ctx, mpb.WithWidth(32))
+	src := &bigQueryDatasetSource{service: service, fetcher: f}
+	fetchProjects(ctx, pb, results, datasets, src, func(ctx context.Context, project string) ([]metadata.Binding, error) {
+		var bindings []metadata.Binding
+		err := f.do(ctx, func() error {
+			var err error
+			bindings, err = listBindings(ctx, iam, project)
+			return err
+		})
+		return bindings, err
+	}, cached, concurrency)
+	pb.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("interrupted: %s", err)
+	}
+
+	var failed []*projectResult
+	for _, r := range results {
+		if r.failed() {
+			failed = append(failed, r)
+		}
+	}
+	if len(failed) > 0 {
+		fmt.Fprintf(os.Stderr, "failed to cache %d of %d projects:\n", len(failed), len(results))
+		for _, r := range failed {
+			fmt.Fprintf(os.Stderr, "  %s: %s\n", r.project, r.err)
+		}
+		if !allowPartial {
+			return errors.New("cache is not saved (use --allow-partial to save the other projects)")
+		}
+	}
+
+	metas := buildMetas(results, prev, time.Now(), version)
+	err = writeCache(&metas)
+	if err != nil {
+		return fmt.Errorf("failed to save cache: %s", err)
+	}
+
+	fmt.Printf("dataset meta data are cached to %s\n", config.CacheFile)
+	return nil
+}
 
-		go func() {
-			client, err := bq.NewClient(ctx, p)
+func newCacheConvertCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "convert [source] [destination]",
+		Short: "Converts the cache file into another format",
+		Long: `
+This subcommand converts the cache file into another format (` + strings.Join(metadata.Formats, ", ") + `),
+detected from the file extensions (.toml, .json, .json.gz, .db) unless --from or --to is given.
+For example:
+
+bqiam cache convert ~/.bqiam-cache.toml ~/.bqiam-cache.json.gz
+`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			from, err := cmd.Flags().GetString("from")
 			if err != nil {
-				fatalErrors <- err
+				return fmt.Errorf("failed to parse from flag: %s", err)
 			}
-			defer client.Close()
+			to, err := cmd.Flags().GetString("to")
+			if err != nil {
+				return fmt.Errorf("failed to parse to flag: %s", err)
+			}
+
+			src, dst := args[0], args[1]
+			if err := convertCache(src, from, dst, to); err != nil {
+				return err
+			}
+			fmt.Printf("converted %s to %s\n", src, dst)
+			return nil
+		},
+	}
+
+	cmd.Flags().String("from", "", "Format of the source file (default is detected from the extension)")
+	cmd.Flags().String("to", "", "Format of the destination file (default is detected from the extension)")
+
+	return cmd
+}
 
-			for _, d := range *ds {
-				projectMetas, err := listMetaData(ctx, client, p, d)
+// convertCache reads the cache src and writes it to dst, which are file paths or object URLs.
+// Empty formats are detected from the file extensions.
+func convertCache(src, srcFormat, dst, dstFormat string) error {
+	if srcFormat == "" {
+		srcFormat = metadata.FormatOf(src)
+	}
+	if dstFormat == "" {
+		dstFormat = metadata.FormatOf(dst)
+	}
+
+	ctx := context.Background()
+	var ms metadata.Metas
+	if err := loadCacheFile(ctx, src, srcFormat, &ms); err != nil {
+		return err
+	}
+	return saveCacheFile(ctx, dst, dstFormat, &ms)
+}
+
+// fetchProjects fetches the access entries of all (project, dataset) pairs and the IAM policies of the projects
+// not failed yet, showing the progress of each project on pb.
+// The results are stored by index to keep the order of the cache stable.
+func fetchProjects(ctx context.Context, pb *mpb.Progress, results []*projectResult, datasets [][]string, src datasetSource,
+	listBindings func(ctx context.Context, project string) ([]metadata.Binding, error), cached map[string]cachedDataset, concurrency int,
+) {
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(concurrency)
+	for i, r := range results {
+		if r.failed() {
+			continue
+		}
+		p := r.project
+		r.bar = NewBar(pb, int64(len(datasets[i])), fmt.Sprintf("[%v/%v][%v] caching datasets...", i+1, len(results), p))
+		if len(datasets[i]) == 0 {
+			r.bar.SetTotal(0, true) // a bar with no datasets never completes by itself
+		}
+		r.datasets = make([]cachedDataset, len(datasets[i]))
+
+		for j, d := range datasets[i] {
+			g.Go(func() error {
+				if r.failed() {
+					return nil
+				}
+				dataset, datasetMetas, err := refreshDataset(gctx, src, p, d, cached)
 				if err != nil {
-					err = fmt.Errorf("failed to fetch metadata: project %s, error %s", p, err)
-					fatalErrors <- err
+					r.fail(fmt.Errorf("failed to fetch metadata: %s", err))
+					return nil
 				}
-				mutex.Lock()
-				metas.Metas = append(metas.Metas, projectMetas.Metas...)
-				mutex.Unlock()
-				bar.Increment()
+				r.datasets[j] = cachedDataset{dataset: dataset, metas: datasetMetas}
+				r.bar.Increment()
+				return nil
+			})
+		}
+
+		g.Go(func() error {
+			b, err := listBindings(gctx, p)
+			if err != nil {
+				r.fail(fmt.Errorf("failed to fetch IAM policy: %s", err))
+				return nil
 			}
-		}()
+			r.bindings = b
+			return nil
+		})
 	}
+	_ = g.Wait() // failures are recorded to results
+}
 
-	go func() {
-		pb.Wait()
-		close(wgDone)
-	}()
+// projectResult is what is fetched for a project, or the first error that stopped it.
+type projectResult struct {
+	project  string
+	datasets []cachedDataset
+	bindings []metadata.Binding
+	bar      *mpb.Bar
 
-	select {
-	case <-wgDone:
-		break // carry on
-	case err := <-fatalErrors:
-		close(fatalErrors)
-		return err
+	mu  sync.Mutex
+	err error
+}
+
+func (r *projectResult) fail(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	r.err = err
+	if r.bar != nil {
+		r.bar.Abort(false)
+	}
+}
+
+func (r *projectResult) failed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err != nil
+}
+
+// buildMetas assembles the cache made at now from the results.
+// The failed projects are recorded in the header, keeping their entries in prev if any.
+func buildMetas(results []*projectResult, prev metadata.Metas, now time.Time, version string) metadata.Metas {
+	metas := metadata.Metas{
+		Header: metadata.Header{CreatedAt: now, Version: version},
 	}
+	for _, r := range results {
+		if r.err != nil {
+			status := metadata.ProjectStatus{Project: r.project, Status: metadata.StatusFailed, Error: r.err.Error()}
+			if p, ok := prev.Header.Project(r.project); ok {
+				status.FetchedAt = p.FetchedAt
+			}
+			metas.Header.Projects = append(metas.Header.Projects, status)
+			metas.Datasets = append(metas.Datasets, filterByProject(prev.Datasets, r.project, func(d metadata.Dataset) string { return d.Project })...)
+			metas.Metas = append(metas.Metas, filterByProject(prev.Metas, r.project, func(m metadata.Meta) string { return m.Project })...)
+			metas.Bindings = append(metas.Bindings, filterByProject(prev.Bindings, r.project, func(b metadata.Binding) string { return b.Project })...)
+			continue
+		}
 
-	err = metas.Save(config.CacheFile)
-	if err != nil {
-		return fmt.Errorf("failed to save cache: %s", err)
+		metas.Header.Projects = append(metas.Header.Projects, metadata.ProjectStatus{Project: r.project, Status: metadata.StatusOK, FetchedAt: now})
+		for _, d := range r.datasets {
+			metas.Datasets = append(metas.Datasets, d.dataset)
+			metas.Metas = append(metas.Metas, d.metas...)
+		}
+		metas.Bindings = append(metas.Bindings, r.bindings...)
 	}
+	return metas
+}
 
-	fmt.Printf("dataset meta data are cached to %s\n", config.CacheFile)
-	return nil
+func filterByProject[T any](s []T, project string, projectOf func(T) string) []T {
+	var res []T
+	for _, v := range s {
+		if projectOf(v) == project {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// cacheFormat returns the format of the cache file, CacheBackend if set or else from the file extension.
+func cacheFormat() string {
+	if config.CacheBackend != "" {
+		return config.CacheBackend
+	}
+	return metadata.FormatOf(config.CacheFile)
+}
+
+// readCache loads the cache file in the configured format.
+func readCache(ms *metadata.Metas) error {
+	return loadCacheFile(context.Background(), config.CacheFile, cacheFormat(), ms)
+}
+
+// readCacheFiltered loads the cache like readCache, but only the entries selected by f if the cache is SQLite.
+func readCacheFiltered(ms *metadata.Metas, f metadata.Filter) error {
+	if cacheFormat() != metadata.FormatSQLite {
+		return readCache(ms)
+	}
+	return withCacheFile(context.Background(), config.CacheFile, func(file string) error {
+		return ms.LoadSQLiteFiltered(file, f)
+	})
+}
+
+// writeCache saves the cache file in the configured format.
+func writeCache(ms *metadata.Metas) error {
+	return saveCacheFile(context.Background(), config.CacheFile, cacheFormat(), ms)
 }
 
 func listProjects(ctx context.Context) (*[]string, error) {
@@ -143,13 +395,7 @@ func listProjects(ctx context.Context) (*[]string, error) {
 	return &projects, nil
 }
 
-func listDataSets(ctx context.Context, project string) (*[]string, error) {
-	client, err := bq.NewClient(ctx, project)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create client: %w", err)
-	}
-	defer client.Close()
-
+func listDataSets(ctx context.Context, client *bq.Client) ([]string, error) {
 	it := client.Datasets(ctx)
 	var datasets []string
 	for {
@@ -162,26 +408,148 @@ func listDataSets(ctx context.Context, project string) (*[]string, error) {
 		}
 		datasets = append(datasets, ds.DatasetID)
 	}
-	return &datasets, nil
+	return datasets, nil
+}
+
+// datasetSource fetches the access entries of datasets.
+type datasetSource interface {
+	// Fetch returns the dataset and its access entries.
+	// If etag is not empty and the dataset has not changed since its ETag was etag, it returns modified false without them.
+	Fetch(ctx context.Context, project, dataset, etag string) (d metadata.Dataset, metas []metadata.Meta, modified bool, err error)
+}
+
+type cachedDataset struct {
+	dataset metadata.Dataset
+	metas   []metadata.Meta
+}
+
+// cachedDatasets indexes the datasets in the cache by "project.dataset".
+func cachedDatasets(ms metadata.Metas) map[string]cachedDataset {
+	cached := map[string]cachedDataset{}
+	for _, d := range ms.Datasets {
+		cached[d.Project+"."+d.Dataset] = cachedDataset{dataset: d}
+	}
+	for _, m := range ms.Metas {
+		key := m.Project + "." + m.Dataset
+		if c, ok := cached[key]; ok {
+			c.metas = append(c.metas, m)
+			cached[key] = c
+		}
+	}
+	return cached
 }
 
-func listMetaData(ctx context.Context, client *bq.Client, project, dataset string) (metadata.Metas, error) {
-	md, err := client.Dataset(dataset).Metadata(ctx)
+// refreshDataset returns the cached access entries of the dataset if it's not modified, or the fetched ones.
+func refreshDataset(ctx context.Context, src datasetSource, project, dataset string, cached map[string]cachedDataset) (metadata.Dataset, []metadata.Meta, error) {
+	c := cached[project+"."+dataset] // the ETag is empty if not cached or cached by an older version
+	d, metas, modified, err := src.Fetch(ctx, project, dataset, c.dataset.ETag)
 	if err != nil {
-		return metadata.Metas{}, fmt.Errorf("failed to fetch dataset metadata: project %s, dataset %s: %w", project, dataset, err)
+		return metadata.Dataset{}, nil, err
+	}
+	if !modified {
+		return c.dataset, c.metas, nil
 	}
+	return d, metas, nil
+}
+
+// bigQueryDatasetSource fetches datasets with conditional requests to the BigQuery API,
+// so that a dataset costs one request whether it's modified or not.
+type bigQueryDatasetSource struct {
+	service *bigquery.Service
+	fetcher *fetcher
+}
 
-	var metas metadata.Metas
-	for _, a := range md.Access {
-		d := metadata.Meta{
-			Project: project,
-			Dataset: dataset,
-			Role:    a.Role,
-			Entity:  a.Entity,
+func (s *bigQueryDatasetSource) Fetch(ctx context.Context, project, dataset, etag string) (metadata.Dataset, []metadata.Meta, bool, error) {
+	var ds *bigquery.Dataset
+	modified := true
+	err := s.fetcher.do(ctx, func() error {
+		call := s.service.Datasets.Get(project, dataset).Context(ctx)
+		if etag != "" {
+			call = call.IfNoneMatch(etag)
+		}
+		var err error
+		ds, err = call.Do()
+		if etag != "" && googleapi.IsNotModified(err) {
+			modified = false
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return metadata.Dataset{}, nil, false, fmt.Errorf("failed to fetch dataset metadata: project %s, dataset %s: %w", project, dataset, err)
+	}
+	if !modified {
+		return metadata.Dataset{}, nil, false, nil
+	}
+
+	var metas []metadata.Meta
+	for _, a := range ds.Access {
+		e := accessEntry(a)
+		metas = append(metas, metadata.Meta{
+			Project:    project,
+			Dataset:    dataset,
+			Role:       e.Role,
+			EntityType: bqrole.EntityTypeName(e.EntityType),
+			Entity:     bqrole.EntityName(e),
+		})
+	}
+	d := metadata.Dataset{Project: project, Dataset: dataset, ETag: ds.Etag, LastModified: time.UnixMilli(ds.LastModifiedTime)}
+	return d, metas, true, nil
+}
+
+// accessEntry converts the access entry of the BigQuery API response in the same way as the BigQuery client library.
+func accessEntry(a *bigquery.DatasetAccess) *bq.AccessEntry {
+	e := &bq.AccessEntry{Role: bq.AccessRole(a.Role)}
+	switch {
+	case a.Domain != "":
+		e.EntityType, e.Entity = bq.DomainEntity, a.Domain
+	case a.GroupByEmail != "":
+		e.EntityType, e.Entity = bq.GroupEmailEntity, a.GroupByEmail
+	case a.UserByEmail != "":
+		e.EntityType, e.Entity = bq.UserEmailEntity, a.UserByEmail
+	case a.SpecialGroup != "":
+		e.EntityType, e.Entity = bq.SpecialGroupEntity, a.SpecialGroup
+	case a.View != nil:
+		e.EntityType = bq.ViewEntity
+		e.View = &bq.Table{ProjectID: a.View.ProjectId, DatasetID: a.View.DatasetId, TableID: a.View.TableId}
+	case a.IamMember != "":
+		e.EntityType, e.Entity = bq.IAMMemberEntity, a.IamMember
+	case a.Routine != nil:
+		e.EntityType = bq.RoutineEntity
+		e.Routine = &bq.Routine{ProjectID: a.Routine.ProjectId, DatasetID: a.Routine.DatasetId, RoutineID: a.Routine.RoutineId}
+	case a.Dataset != nil && a.Dataset.Dataset != nil:
+		e.EntityType = bq.DatasetEntity
+		e.Dataset = &bq.DatasetAccessEntry{
+			Dataset:     &bq.Dataset{ProjectID: a.Dataset.Dataset.ProjectId, DatasetID: a.Dataset.Dataset.DatasetId},
+			TargetTypes: a.Dataset.TargetTypes,
+		}
+	}
+	return e
+}
+
+// listBindings returns the members of the IAM roles of the project.
+func listBindings(ctx context.Context, iam bqrole.IAMBackend, project string) ([]metadata.Binding, error) {
+	policy, err := bqrole.FetchCurrentPolicy(ctx, iam, project)
+	if err != nil {
+		return nil, err
+	}
+
+	var bindings []metadata.Binding
+	for _, b := range policy.Bindings {
+		var condition string
+		if b.Condition != nil {
+			condition = b.Condition.Expression
+		}
+		for _, m := range b.Members {
+			bindings = append(bindings, metadata.Binding{
+				Project:   project,
+				Role:      b.Role,
+				Member:    m,
+				Condition: condition,
+			})
 		}
-		metas.Metas = append(metas.Metas, d)
 	}
-	return metas, nil
+	return bindings, nil
 }
 
 func isBigQueryProject(project string) bool {
@@ -209,5 +577,8 @@ func NewBar(pb *mpb.Progress, max int64, name string) *mpb.Bar {
 }
 
 func init() {
+	cacheCmd.Flags().Bool("allow-partial", false, "Save the cache even if some projects failed, recording them in the cache")
+	cacheCmd.Flags().Bool("full", false, "Re-read all datasets instead of only the ones modified since the last run")
+	cacheCmd.AddCommand(newCacheConvertCmd())
 	rootCmd.AddCommand(cacheCmd)
 }
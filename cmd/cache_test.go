@@ -0,0 +1,293 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	mpb "github.com/vbauerster/mpb/v8"
+	"google.golang.org/api/bigquery/v2"
+
+	"github.com/hirosassa/bqiam/bqrole"
+	"github.com/hirosassa/bqiam/metadata"
+)
+
+// fakeDatasetSource serves datasets by "project.dataset" and records requests and modified responses.
+type fakeDatasetSource struct {
+	datasets map[string]metadata.Dataset
+	metas    map[string][]metadata.Meta
+	requests int
+	fetched  []string
+}
+
+func (s *fakeDatasetSource) Fetch(ctx context.Context, project, dataset, etag string) (metadata.Dataset, []metadata.Meta, bool, error) {
+	key := project + "." + dataset
+	s.requests++
+	if etag != "" && s.datasets[key].ETag == etag {
+		return metadata.Dataset{}, nil, false, nil
+	}
+	s.fetched = append(s.fetched, key)
+	return s.datasets[key], s.metas[key], true, nil
+}
+
+func TestRefreshDataset(t *testing.T) {
+	modified := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	prev := metadata.Metas{
+		Metas: []metadata.Meta{
+			{Project: "prj", Dataset: "ds1", Role: bq.ReaderRole, EntityType: "user", Entity: "user1@email.com"},
+			{Project: "prj", Dataset: "ds2", Role: bq.ReaderRole, EntityType: "user", Entity: "user1@email.com"},
+			{Project: "prj", Dataset: "ds3", Role: bq.ReaderRole, EntityType: "user", Entity: "user1@email.com"},
+		},
+		Datasets: []metadata.Dataset{
+			{Project: "prj", Dataset: "ds1", ETag: "etag1", LastModified: modified},
+			{Project: "prj", Dataset: "ds2", ETag: "etag2", LastModified: modified},
+		},
+	}
+	src := &fakeDatasetSource{
+		datasets: map[string]metadata.Dataset{
+			"prj.ds1": {Project: "prj", Dataset: "ds1", ETag: "etag1", LastModified: modified},
+			"prj.ds2": {Project: "prj", Dataset: "ds2", ETag: "etag2-new", LastModified: modified.Add(time.Hour)},
+			"prj.ds3": {Project: "prj", Dataset: "ds3", ETag: "etag3", LastModified: modified},
+		},
+		metas: map[string][]metadata.Meta{
+			"prj.ds2": {{Project: "prj", Dataset: "ds2", Role: bq.WriterRole, EntityType: "user", Entity: "user1@email.com"}},
+			"prj.ds3": {{Project: "prj", Dataset: "ds3", Role: bq.ReaderRole, EntityType: "user", Entity: "user1@email.com"}},
+		},
+	}
+
+	cases := []struct {
+		dataset   string
+		wantMetas []metadata.Meta
+		wantFetch bool
+	}{
+		{dataset: "ds1", wantMetas: prev.Metas[:1], wantFetch: false},      // not modified
+		{dataset: "ds2", wantMetas: src.metas["prj.ds2"], wantFetch: true}, // modified
+		{dataset: "ds3", wantMetas: src.metas["prj.ds3"], wantFetch: true}, // cached without ETag by an older version
+	}
+
+	cached := cachedDatasets(prev)
+	for _, c := range cases {
+		t.Run(c.dataset, func(t *testing.T) {
+			src.requests, src.fetched = 0, nil
+			dataset, metas, err := refreshDataset(context.Background(), src, "prj", c.dataset, cached)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(metas, c.wantMetas) {
+				t.Errorf("got: %+v, want: %+v", metas, c.wantMetas)
+			}
+			if want := src.datasets["prj."+c.dataset]; dataset != want {
+				t.Errorf("got: %+v, want: %+v", dataset, want)
+			}
+			if (len(src.fetched) > 0) != c.wantFetch {
+				t.Errorf("got fetched: %v, want fetch: %v", src.fetched, c.wantFetch)
+			}
+			if src.requests != 1 {
+				t.Errorf("got %d requests, want 1", src.requests)
+			}
+		})
+	}
+}
+
+func TestFetchProjects(t *testing.T) {
+	src := &fakeDatasetSource{
+		datasets: map[string]metadata.Dataset{"prj1.ds1": {Project: "prj1", Dataset: "ds1", ETag: "etag1"}},
+		metas: map[string][]metadata.Meta{
+			"prj1.ds1": {{Project: "prj1", Dataset: "ds1", Role: bq.ReaderRole, EntityType: "user", Entity: "user1@email.com"}},
+		},
+	}
+	listBindings := func(ctx context.Context, project string) ([]metadata.Binding, error) {
+		if project == "prj3" {
+			return nil, errors.New("permission denied")
+		}
+		return []metadata.Binding{{Project: project, Role: "roles/viewer", Member: "user:user1@email.com"}}, nil
+	}
+	results := []*projectResult{{project: "prj1"}, {project: "prj2"}, {project: "prj3"}}
+	datasets := [][]string{{"ds1"}, {}, {}} // prj2 and prj3 have no datasets
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pb := mpb.New(mpb.WithOutput(io.Discard))
+		fetchProjects(context.Background(), pb, results, datasets, src, listBindings, nil, 2)
+		pb.Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("fetchProjects did not finish")
+	}
+
+	want := buildMetas([]*projectResult{
+		{
+			project: "prj1",
+			datasets: []cachedDataset{{
+				dataset: src.datasets["prj1.ds1"],
+				metas:   src.metas["prj1.ds1"],
+			}},
+			bindings: []metadata.Binding{{Project: "prj1", Role: "roles/viewer", Member: "user:user1@email.com"}},
+		},
+		{
+			project:  "prj2",
+			datasets: []cachedDataset{},
+			bindings: []metadata.Binding{{Project: "prj2", Role: "roles/viewer", Member: "user:user1@email.com"}},
+		},
+		{project: "prj3", err: errors.New("failed to fetch IAM policy: permission denied")},
+	}, metadata.Metas{}, time.Time{}, "")
+	got := buildMetas(results, metadata.Metas{}, time.Time{}, "")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %+v, want: %+v", got, want)
+	}
+}
+
+func TestBuildMetas(t *testing.T) {
+	now := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	fetched := now.Add(-24 * time.Hour)
+	prev := metadata.Metas{
+		Header: metadata.Header{
+			CreatedAt: fetched,
+			Projects: []metadata.ProjectStatus{
+				{Project: "prj1", Status: metadata.StatusOK, FetchedAt: fetched},
+				{Project: "prj2", Status: metadata.StatusOK, FetchedAt: fetched},
+			},
+		},
+		Metas: []metadata.Meta{
+			{Project: "prj1", Dataset: "ds1", Role: bq.ReaderRole, EntityType: "user", Entity: "old@email.com"},
+			{Project: "prj2", Dataset: "ds2", Role: bq.ReaderRole, EntityType: "user", Entity: "old@email.com"},
+		},
+		Bindings: []metadata.Binding{{Project: "prj2", Role: "roles/viewer", Member: "user:old@email.com"}},
+		Datasets: []metadata.Dataset{{Project: "prj2", Dataset: "ds2", ETag: "etag2"}},
+	}
+	results := []*projectResult{
+		{
+			project: "prj1",
+			datasets: []cachedDataset{{
+				dataset: metadata.Dataset{Project: "prj1", Dataset: "ds1", ETag: "etag1"},
+				metas:   []metadata.Meta{{Project: "prj1", Dataset: "ds1", Role: bq.WriterRole, EntityType: "user", Entity: "new@email.com"}},
+			}},
+			bindings: []metadata.Binding{{Project: "prj1", Role: "roles/editor", Member: "user:new@email.com"}},
+		},
+		{project: "prj2", err: errors.New("permission denied")},
+		{project: "prj3", err: errors.New("quota exceeded")},
+	}
+
+	want := metadata.Metas{
+		Header: metadata.Header{
+			CreatedAt: now,
+			Version:   "v1.0.0",
+			Projects: []metadata.ProjectStatus{
+				{Project: "prj1", Status: metadata.StatusOK, FetchedAt: now},
+				{Project: "prj2", Status: metadata.StatusFailed, Error: "permission denied", FetchedAt: fetched},
+				{Project: "prj3", Status: metadata.StatusFailed, Error: "quota exceeded"},
+			},
+		},
+		Metas: []metadata.Meta{
+			{Project: "prj1", Dataset: "ds1", Role: bq.WriterRole, EntityType: "user", Entity: "new@email.com"},
+			{Project: "prj2", Dataset: "ds2", Role: bq.ReaderRole, EntityType: "user", Entity: "old@email.com"},
+		},
+		Bindings: []metadata.Binding{
+			{Project: "prj1", Role: "roles/editor", Member: "user:new@email.com"},
+			{Project: "prj2", Role: "roles/viewer", Member: "user:old@email.com"},
+		},
+		Datasets: []metadata.Dataset{
+			{Project: "prj1", Dataset: "ds1", ETag: "etag1"},
+			{Project: "prj2", Dataset: "ds2", ETag: "etag2"},
+		},
+	}
+
+	got := buildMetas(results, prev, now, "v1.0.0")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %+v\nwant: %+v", got, want)
+	}
+}
+
+func TestConvertCache(t *testing.T) {
+	dir := t.TempDir()
+	ms := metadata.Metas{
+		Header: metadata.Header{Version: "v1.0.0"},
+		Metas:  []metadata.Meta{{Project: "prj1", Dataset: "ds1", Role: bq.ReaderRole, EntityType: "user", Entity: "user1@email.com"}},
+	}
+	src := filepath.Join(dir, "cache.toml")
+	if err := ms.Save(src); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		name      string
+		dst       string
+		dstFormat string
+		wantErr   bool
+	}{
+		{name: "by extension", dst: "cache.json.gz"},
+		{name: "by flag", dst: "cache", dstFormat: metadata.FormatSQLite},
+		{name: "unknown format", dst: "cache.xml", dstFormat: "xml", wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dst := filepath.Join(dir, c.dst)
+			err := convertCache(src, "", dst, c.dstFormat)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("got err: %v, wantErr: %v", err, c.wantErr)
+			}
+			if c.wantErr {
+				return
+			}
+
+			format := c.dstFormat
+			if format == "" {
+				format = metadata.FormatOf(dst)
+			}
+			s, err := metadata.NewStorage(format)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var got metadata.Metas
+			if err := s.Load(dst, &got); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, ms) {
+				t.Errorf("got: %+v\nwant: %+v", got, ms)
+			}
+		})
+	}
+}
+
+func TestAccessEntry(t *testing.T) {
+	cases := []struct {
+		access         *bigquery.DatasetAccess
+		wantEntityType string
+		wantEntity     string
+	}{
+		{access: &bigquery.DatasetAccess{Role: "READER", UserByEmail: "user1@email.com"}, wantEntityType: "user", wantEntity: "user1@email.com"},
+		{access: &bigquery.DatasetAccess{Role: "READER", GroupByEmail: "group1@email.com"}, wantEntityType: "group", wantEntity: "group1@email.com"},
+		{access: &bigquery.DatasetAccess{Role: "OWNER", SpecialGroup: "projectOwners"}, wantEntityType: "specialGroup", wantEntity: "projectOwners"},
+		{
+			access:         &bigquery.DatasetAccess{View: &bigquery.TableReference{ProjectId: "prj", DatasetId: "ds", TableId: "view1"}},
+			wantEntityType: "view",
+			wantEntity:     "prj.ds.view1",
+		},
+		{
+			access:         &bigquery.DatasetAccess{Dataset: &bigquery.DatasetAccessEntry{Dataset: &bigquery.DatasetReference{ProjectId: "prj", DatasetId: "ds"}}},
+			wantEntityType: "dataset",
+			wantEntity:     "prj.ds",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.wantEntity, func(t *testing.T) {
+			e := accessEntry(c.access)
+			if got := bqrole.EntityTypeName(e.EntityType); got != c.wantEntityType {
+				t.Errorf("got entity type: %s, want: %s", got, c.wantEntityType)
+			}
+			if got := bqrole.EntityName(e); got != c.wantEntity {
+				t.Errorf("got entity: %s, want: %s", got, c.wantEntity)
+			}
+		})
+	}
+}
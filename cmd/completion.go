@@ -6,6 +6,8 @@ import (
 	"os"
 	"strings"
 
+	bq "cloud.google.com/go/bigquery"
+
 	"github.com/hirosassa/bqiam/bqrole"
 	"github.com/hirosassa/bqiam/completion"
 
@@ -78,16 +80,26 @@ func createCompletionFile() error {
 	list.Projects = config.BigqueryProjects
 
 	for _, project := range list.Projects {
-		datasets, err := listDataSets(ctx, project)
+		client, err := bq.NewClient(ctx, project)
+		if err != nil {
+			return fmt.Errorf("failed to create bigquery Client: %s", err)
+		}
+		datasets, err := listDataSets(ctx, client)
+		client.Close()
 		if err != nil {
 			return err
 		}
 
-		list.Datasets = append(list.Datasets, *datasets...)
+		list.Datasets = append(list.Datasets, datasets...)
+	}
+
+	iam, err := bqrole.NewResourceManagerBackend(ctx)
+	if err != nil {
+		return err
 	}
 
 	for _, project := range list.Projects {
-		policy, err := bqrole.FetchCurrentPolicy(project)
+		policy, err := bqrole.FetchCurrentPolicy(ctx, iam, project)
 		if err != nil {
 			return err
 		}
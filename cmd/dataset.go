@@ -2,25 +2,43 @@ package cmd
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"time"
 
+	bq "cloud.google.com/go/bigquery"
 	"github.com/spf13/cobra"
 	"gopkg.in/djherbis/times.v1"
 
+	"github.com/hirosassa/bqiam/blob"
+	"github.com/hirosassa/bqiam/bqrole"
+	"github.com/hirosassa/bqiam/groups"
 	"github.com/hirosassa/bqiam/metadata"
 )
 
 // datasetCmd represents the dataset command
 var datasetCmd = &cobra.Command{
-	Use:   "dataset [user email (required)]",
+	Use:   "dataset [user email or pattern (required)]",
 	Short: "List datasets that the input user or service account has permissions",
 	Long: `
 This subcommand returns a list of datasets
 that the input user or service account is able to access.
+The entity can also be a group, a domain, a special group like projectReaders,
+or an authorized view, routine or dataset like project.dataset.view.
+For example:
+
+bqiam dataset user1@email.com
+bqiam dataset projectReaders --entity-type specialGroup
+
+The entity can be a glob like '*@email.com', an email domain like @email.com,
+or a regular expression with --regex, and the result can be filtered by project, dataset prefix and role:
+
+bqiam dataset @contractor.com --role WRITER
+bqiam dataset '^svc-.*' --regex -p bq-project-id --dataset-prefix staging_
 `,
 	Args: func(cmd *cobra.Command, args []string) error {
 		if len(args) != 1 {
@@ -32,50 +50,332 @@ that the input user or service account is able to access.
 }
 
 func runCmdDataset(cmd *cobra.Command, args []string) error {
-	refreshCache(cmd) // refresh cache if needed
+	filter, err := newAccessFilter(cmd)
+	if err != nil {
+		return err
+	}
 
-	var ms metadata.Metas
-	if err := ms.Load(config.CacheFile); err != nil {
+	regex, err := cmd.Flags().GetBool("regex")
+	if err != nil {
+		return fmt.Errorf("failed to parse regex flag: %s", err)
+	}
+
+	matcher, err := newEntityMatcher(args[0], regex)
+	if err != nil {
+		return err
+	}
+
+	expand, err := cmd.Flags().GetBool("expand-groups")
+	if err != nil {
+		return fmt.Errorf("failed to parse expand-groups flag: %s", err)
+	}
+	if expand && matcher.exact == "" {
+		return errors.New("--expand-groups needs an exact entity, not a pattern")
+	}
+
+	ctx := context.Background()
+	var expander *groups.Expander
+	if expand {
+		resolver, err := newGroupResolver(ctx)
+		if err != nil {
+			return err
+		}
+		expander = groups.NewExpander(resolver)
+	}
+
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return fmt.Errorf("failed to parse output flag: %s", err)
+	}
+
+	cacheFilter := filter.cacheFilter()
+	if matcher.exact != "" && expander == nil {
+		// the access through groups needs all the group entries
+		cacheFilter.Entities = []string{matcher.exact}
+		cacheFilter.Members = bindingMembers(matcher.exact)
+	}
+	ms, err := loadCache(cmd, cacheFilter) // refresh cache if needed
+	if err != nil {
 		return err
 	}
+	warnCacheScope(ms, filter.projects)
 
-	entity := args[0]
+	var as []access
+	for _, a := range accessOf(ctx, effectiveAccess(ms), matcher, expander) {
+		if filter.matches(a) {
+			as = append(as, a)
+		}
+	}
+
+	return writeAccess(os.Stdout, output, as, func(w io.Writer, a access) {
+		if len(a.Via) > 0 {
+			fmt.Fprintln(w, a.Project, a.Dataset, a.Role, a.EntityType, a.Source, "via:"+strings.Join(a.Via, ">"))
+		} else {
+			fmt.Fprintln(w, a.Project, a.Dataset, a.Role, a.EntityType, a.Source)
+		}
+	})
+}
+
+// accessOf returns the access of the entities matched by matcher.
+// With expander, it also returns the access granted to the groups the exact entity belongs to, with the path of groups in Via.
+func accessOf(ctx context.Context, as []access, matcher entityMatcher, expander *groups.Expander) []access {
+	var res []access
+	for _, a := range as {
+		if matcher.match(a.Entity) {
+			res = append(res, a)
+			continue
+		}
+		if expander == nil || matcher.exact == "" || a.EntityType != bqrole.EntityTypeName(bq.GroupEmailEntity) {
+			continue
+		}
+		if path := expander.Path(ctx, a.Entity, matcher.exact); path != nil {
+			a.Via = path
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+// newAccessFilter builds the filter from the flags.
+func newAccessFilter(cmd *cobra.Command) (accessFilter, error) {
+	var f accessFilter
+	var err error
+
+	f.projects, err = cmd.Flags().GetStringSlice("project")
+	if err != nil {
+		return f, fmt.Errorf("failed to parse project flag: %s", err)
+	}
+
+	f.datasetPrefix, err = cmd.Flags().GetString("dataset-prefix")
+	if err != nil {
+		return f, fmt.Errorf("failed to parse dataset-prefix flag: %s", err)
+	}
+
+	roles, err := cmd.Flags().GetStringSlice("role")
+	if err != nil {
+		return f, fmt.Errorf("failed to parse role flag: %s", err)
+	}
+	for _, r := range roles {
+		role, err := bqrole.DatasetRole(r)
+		if err != nil {
+			return f, fmt.Errorf("READER or WRITER or OWNER must be specified: %s", err)
+		}
+		f.roles = append(f.roles, role)
+	}
+
+	f.entityTypes, err = cmd.Flags().GetStringSlice("entity-type")
+	if err != nil {
+		return f, fmt.Errorf("failed to parse entity-type flag: %s", err)
+	}
+	for _, t := range f.entityTypes {
+		if _, err := bqrole.ParseEntityType(t); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+// newGroupResolver returns the resolver of group members from the groups file if configured, or from Cloud Identity.
+func newGroupResolver(ctx context.Context) (groups.Resolver, error) {
+	if config.GroupsFile != "" {
+		return groups.LoadFileResolver(config.GroupsFile)
+	}
+	return groups.NewCloudIdentityResolver(ctx)
+}
+
+// access is an access of an entity to a dataset, granted on the dataset or inherited from a project-level role.
+type access struct {
+	Project    string        `json:"project" yaml:"project"`
+	Dataset    string        `json:"dataset" yaml:"dataset"`
+	Role       bq.AccessRole `json:"role" yaml:"role"`
+	EntityType string        `json:"entityType" yaml:"entityType"`
+	Entity     string        `json:"entity" yaml:"entity"`
+	Source     string        `json:"source" yaml:"source"`               // "dataset", or "project:<role>" if inherited from the project-level role
+	Via        []string      `json:"via,omitempty" yaml:"via,omitempty"` // groups the queried entity gets the access through, outermost first
+}
+
+// effectiveAccess returns the dataset access entries in the cache,
+// followed by the access to every cached dataset of a project inherited from its project-level roles.
+func effectiveAccess(ms metadata.Metas) []access {
+	var as []access
+	for _, m := range ms.Metas {
+		as = append(as, access{
+			Project:    m.Project,
+			Dataset:    m.Dataset,
+			Role:       m.Role,
+			EntityType: entityTypeOf(m),
+			Entity:     m.Entity,
+			Source:     "dataset",
+		})
+	}
+
+	datasets := cachedDatasetNames(ms)
+
+	for _, b := range ms.Bindings {
+		role, ok := bqrole.ImpliedDatasetRole(b.Role)
+		if !ok {
+			continue
+		}
+
+		entityType, entity := bindingEntity(b.Member)
+		source := "project:" + b.Role
+		if b.Condition != "" {
+			source += "(conditional)"
+		}
+		for _, d := range datasets[b.Project] {
+			as = append(as, access{
+				Project:    b.Project,
+				Dataset:    d,
+				Role:       role,
+				EntityType: entityType,
+				Entity:     entity,
+				Source:     source,
+			})
+		}
+	}
+	return as
+}
+
+// cachedDatasetNames returns the names of the cached datasets by project,
+// from the datasets in the cache or from the access entries in caches made by older versions.
+func cachedDatasetNames(ms metadata.Metas) map[string][]string {
+	datasets := map[string][]string{}
+	if len(ms.Datasets) > 0 {
+		for _, d := range ms.Datasets {
+			datasets[d.Project] = append(datasets[d.Project], d.Dataset)
+		}
+		return datasets
+	}
+
+	seen := map[string]bool{}
 	for _, m := range ms.Metas {
-		if m.Entity == entity {
-			fmt.Println(m.Project, m.Dataset, m.Role)
+		if key := m.Project + "." + m.Dataset; !seen[key] {
+			seen[key] = true
+			datasets[m.Project] = append(datasets[m.Project], m.Dataset)
 		}
 	}
-	return nil
+	return datasets
+}
+
+// bindingMembers returns the IAM policy members whose entity is entity, the inverse of bindingEntity.
+func bindingMembers(entity string) []string {
+	members := []string{entity}
+	for _, t := range []string{bqrole.UserMember, bqrole.GroupMember, bqrole.ServiceAccountMember, bqrole.DomainMember} {
+		members = append(members, t+":"+entity)
+	}
+	return members
 }
 
-// refreshCache checks the cacheFile and refresh if needed and the user confirmed.
+// bindingEntity returns the entity type and the entity of the IAM policy member, as they would be in dataset access entries.
+func bindingEntity(member string) (string, string) {
+	m, err := bqrole.ParseMember(member)
+	if err != nil || m.Guessed {
+		return bqrole.EntityTypeName(bq.IAMMemberEntity), member
+	}
+	return bqrole.EntityTypeName(m.EntityType()), m.ID
+}
+
+// warnCacheScope warns that the access in the projects not covered by the cache or failed to be cached may be missing or stale.
+func warnCacheScope(ms metadata.Metas, projects []string) {
+	for _, p := range ms.Header.Projects {
+		if p.Status == metadata.StatusFailed {
+			fmt.Fprintf(os.Stderr, "warning: project %s failed to be cached and may be missing or stale: %s\n", p.Project, p.Error)
+		}
+	}
+
+	if ms.Header.CreatedAt.IsZero() {
+		return // made by an older version, which doesn't record the projects
+	}
+	for _, p := range projects {
+		if _, ok := ms.Header.Project(p); !ok {
+			fmt.Fprintf(os.Stderr, "warning: project %s is not in the cache (add it to BigqueryProjects and run `bqiam cache`)\n", p)
+		}
+	}
+}
+
+// loadCache loads the cache, refreshing it first by the CacheRefreshPolicy.
+// Only the entries selected by f are loaded from SQLite caches, and the others are loaded entirely.
+func loadCache(cmd *cobra.Command, f metadata.Filter) (metadata.Metas, error) {
+	var ms metadata.Metas
+	// the cache is made anyway with the always policy
+	if err := readCacheFiltered(&ms, f); err != nil && config.CacheRefreshPolicy != refreshAlways {
+		return ms, err
+	}
+	if !refreshCache(cmd, ms) {
+		return ms, nil
+	}
+
+	var refreshed metadata.Metas
+	if err := readCacheFiltered(&refreshed, f); err != nil {
+		return ms, err
+	}
+	return refreshed, nil
+}
+
+// refreshCache refreshes the cache by the CacheRefreshPolicy, and reports whether it's refreshed before this run continues.
 // refreshCache ignores all the errors occurred.
-func refreshCache(cmd *cobra.Command) {
-	isExpired, _ := checkCacheExpired(config.CacheFile)
-	if isExpired {
-		fmt.Printf("Your cache is old (passed %d hours). Refresh cache? (takes 30-60 sec) [y/n]", config.CacheRefreshHour)
-		reader := bufio.NewReader(os.Stdin)
-		res, err := reader.ReadString('\n')
+func refreshCache(cmd *cobra.Command, ms metadata.Metas) bool {
+	createdAt, err := cacheCreatedAt(ms, config.CacheFile)
+	expired := err == nil && isCacheExpired(createdAt, time.Now())
 
-		if err != nil || strings.TrimSpace(res) != "y" {
-			fmt.Println("Skip refreshing.")
-			return
+	switch refreshAction(config.CacheRefreshPolicy, expired) {
+	case refreshNone:
+		return false
+	case refreshForeground:
+		return runCmdCache(cmd, []string{}) == nil
+	case refreshBackground:
+		logFile, err := startBackgroundRefresh()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: the cache is old (created at %s) and failed to be refreshed: %s\n",
+				createdAt.Local().Format(time.DateTime), err)
+			return false
 		}
+		fmt.Fprintf(os.Stderr, "warning: the cache is old (created at %s), using it while refreshing it in the background (log: %s)\n",
+			createdAt.Local().Format(time.DateTime), logFile)
+		return false
+	}
 
-		_ = runCmdCache(cmd, []string{}) // run cache command to refresh
+	fmt.Printf("Your cache is old (created at %s, passed %d hours). Refresh cache? (takes 30-60 sec) [y/n]",
+		createdAt.Local().Format(time.DateTime), int(time.Since(createdAt).Hours()))
+	reader := bufio.NewReader(os.Stdin)
+	res, err := reader.ReadString('\n')
+
+	if err != nil || strings.TrimSpace(res) != "y" {
+		fmt.Println("Skip refreshing.")
+		return false
 	}
+
+	return runCmdCache(cmd, []string{}) == nil // run cache command to refresh
 }
 
-func checkCacheExpired(filename string) (bool, error) {
+// cacheCreatedAt returns when the cache is created, from the object metadata for shared caches,
+// the header, or the file modified time for caches made by older versions.
+func cacheCreatedAt(ms metadata.Metas, filename string) (time.Time, error) {
+	if blob.IsURL(filename) {
+		return cacheObjectCreatedAt(context.Background(), filename)
+	}
+	if !ms.Header.CreatedAt.IsZero() {
+		return ms.Header.CreatedAt, nil
+	}
+
 	t, err := times.Stat(filename)
 	if err != nil {
-		return false, fmt.Errorf("failed to get file modified timestamp: %s", err)
+		return time.Time{}, fmt.Errorf("failed to get file modified timestamp: %s", err)
 	}
+	return t.ModTime(), nil
+}
 
-	timePassed := time.Since(t.ModTime()).Hours()
-	return timePassed > float64(config.CacheRefreshHour), nil
+func isCacheExpired(createdAt, now time.Time) bool {
+	return now.Sub(createdAt).Hours() > float64(config.CacheRefreshHour)
 }
 
 func init() {
+	datasetCmd.Flags().StringP("output", "o", "text", "Output format (text | table | json | yaml | csv | tsv)")
+	datasetCmd.Flags().Bool("expand-groups", false, "Also list access through the groups the entity belongs to (set GroupsFile in the config to resolve groups offline)")
+	datasetCmd.Flags().Bool("regex", false, "Take the entity as a regular expression")
+	datasetCmd.Flags().StringSliceP("project", "p", []string{}, "Filter by GCP project id(s)")
+	datasetCmd.Flags().String("dataset-prefix", "", "Filter by dataset name prefix")
+	datasetCmd.Flags().StringSlice("role", []string{}, "Filter by role(s) (READER, WRITER or OWNER)")
+	datasetCmd.Flags().StringSliceP("entity-type", "t", []string{}, "Filter by entity type(s) (user, group, domain, specialGroup, iamMember, view, routine or dataset)")
 	rootCmd.AddCommand(datasetCmd)
 }
@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+
+	"github.com/hirosassa/bqiam/groups"
+	"github.com/hirosassa/bqiam/metadata"
+)
+
+func TestEffectiveAccess(t *testing.T) {
+	ms := metadata.Metas{
+		Metas: []metadata.Meta{
+			{Project: "prj", Dataset: "ds1", Role: bq.OwnerRole, EntityType: "specialGroup", Entity: "projectOwners"},
+			{Project: "prj", Dataset: "ds1", Role: bq.ReaderRole, EntityType: "user", Entity: "user1@email.com"},
+			{Project: "prj", Dataset: "ds2", Role: bq.WriterRole, EntityType: "group", Entity: "group1@email.com"},
+			{Project: "other", Dataset: "ds3", Role: bq.ReaderRole, Entity: "user2@email.com"},
+		},
+		Bindings: []metadata.Binding{
+			{Project: "prj", Role: "roles/bigquery.jobUser", Member: "user:user1@email.com"},
+			{Project: "prj", Role: "roles/bigquery.dataViewer", Member: "serviceAccount:sa@prj.iam.gserviceaccount.com"},
+			{Project: "prj", Role: "roles/editor", Member: "group:group1@email.com", Condition: "request.time < timestamp(\"2030-01-01T00:00:00Z\")"},
+		},
+	}
+
+	want := []access{
+		{Project: "prj", Dataset: "ds1", Role: bq.OwnerRole, EntityType: "specialGroup", Entity: "projectOwners", Source: "dataset"},
+		{Project: "prj", Dataset: "ds1", Role: bq.ReaderRole, EntityType: "user", Entity: "user1@email.com", Source: "dataset"},
+		{Project: "prj", Dataset: "ds2", Role: bq.WriterRole, EntityType: "group", Entity: "group1@email.com", Source: "dataset"},
+		{Project: "other", Dataset: "ds3", Role: bq.ReaderRole, EntityType: "unknown", Entity: "user2@email.com", Source: "dataset"},
+		{Project: "prj", Dataset: "ds1", Role: bq.ReaderRole, EntityType: "user", Entity: "sa@prj.iam.gserviceaccount.com", Source: "project:roles/bigquery.dataViewer"},
+		{Project: "prj", Dataset: "ds2", Role: bq.ReaderRole, EntityType: "user", Entity: "sa@prj.iam.gserviceaccount.com", Source: "project:roles/bigquery.dataViewer"},
+		{Project: "prj", Dataset: "ds1", Role: bq.WriterRole, EntityType: "group", Entity: "group1@email.com", Source: "project:roles/editor(conditional)"},
+		{Project: "prj", Dataset: "ds2", Role: bq.WriterRole, EntityType: "group", Entity: "group1@email.com", Source: "project:roles/editor(conditional)"},
+	}
+
+	got := effectiveAccess(ms)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %+v\nwant: %+v", got, want)
+	}
+}
+
+func TestEffectiveAccessFromSQLite(t *testing.T) {
+	ms := metadata.Metas{
+		Metas: []metadata.Meta{
+			{Project: "prj", Dataset: "ds1", Role: bq.OwnerRole, EntityType: "specialGroup", Entity: "projectOwners"},
+			{Project: "prj", Dataset: "ds1", Role: bq.ReaderRole, EntityType: "user", Entity: "user1@email.com"},
+			{Project: "prj", Dataset: "ds2", Role: bq.WriterRole, EntityType: "user", Entity: "user1@email.com"},
+			{Project: "other", Dataset: "ds3", Role: bq.ReaderRole, EntityType: "user", Entity: "user1@email.com"},
+		},
+		Bindings: []metadata.Binding{
+			{Project: "prj", Role: "roles/bigquery.jobUser", Member: "user:user1@email.com"},
+			{Project: "prj", Role: "roles/viewer", Member: "user:user1@email.com"},
+			{Project: "prj", Role: "roles/editor", Member: "user:user2@email.com"},
+			{Project: "other", Role: "roles/bigquery.dataViewer", Member: "user1@email.com"},
+		},
+		Datasets: []metadata.Dataset{
+			{Project: "prj", Dataset: "ds1"},
+			{Project: "prj", Dataset: "ds2"},
+			{Project: "prj", Dataset: "ds4"}, // no access entries
+			{Project: "other", Dataset: "ds3"},
+		},
+	}
+	file := filepath.Join(t.TempDir(), "cache.db")
+	if err := ms.SaveSQLite(file); err != nil {
+		t.Fatal(err)
+	}
+	prev := config
+	t.Cleanup(func() { config = prev })
+	config.CacheFile, config.CacheBackend = file, ""
+
+	cases := []struct {
+		name   string
+		entity string
+		filter accessFilter
+	}{
+		{name: "entity", entity: "user1@email.com"},
+		{name: "entity and role", entity: "user1@email.com", filter: accessFilter{roles: []bq.AccessRole{bq.ReaderRole}}},
+		{name: "entity and project", entity: "user1@email.com", filter: accessFilter{projects: []string{"other"}}},
+		{name: "pattern and dataset prefix", entity: "*@email.com", filter: accessFilter{datasetPrefix: "DS"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			matcher, err := newEntityMatcher(c.entity, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f := c.filter.cacheFilter()
+			if matcher.exact != "" {
+				f.Entities = []string{matcher.exact}
+				f.Members = bindingMembers(matcher.exact)
+			}
+
+			var loaded metadata.Metas
+			if err := readCacheFiltered(&loaded, f); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(loaded.Metas)+len(loaded.Bindings) >= len(ms.Metas)+len(ms.Bindings) && c.filter.datasetPrefix == "" {
+				t.Errorf("all the entries are loaded: %+v", loaded)
+			}
+
+			// the same access as filtering all the entries in memory
+			filtered := func(ms metadata.Metas) []access {
+				var as []access
+				for _, a := range accessOf(context.Background(), effectiveAccess(ms), matcher, nil) {
+					if c.filter.matches(a) {
+						as = append(as, a)
+					}
+				}
+				return as
+			}
+			if got, want := filtered(loaded), filtered(ms); !reflect.DeepEqual(got, want) {
+				t.Errorf("got: %+v\nwant: %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestBindingMembers(t *testing.T) {
+	for _, m := range bindingMembers("user1@email.com") {
+		if _, entity := bindingEntity(m); entity != "user1@email.com" {
+			t.Errorf("entity of %s got: %s, want: user1@email.com", m, entity)
+		}
+	}
+}
+
+func TestAccessOf(t *testing.T) {
+	as := []access{
+		{Project: "prj", Dataset: "ds1", Role: bq.ReaderRole, EntityType: "user", Entity: "user1@email.com", Source: "dataset"},
+		{Project: "prj", Dataset: "ds2", Role: bq.WriterRole, EntityType: "group", Entity: "team@email.com", Source: "dataset"},
+		{Project: "prj", Dataset: "ds3", Role: bq.ReaderRole, EntityType: "group", Entity: "other@email.com", Source: "project:roles/bigquery.dataViewer"},
+	}
+	expander := groups.NewExpander(&groups.FileResolver{Groups: map[string][]string{
+		"team@email.com":  {"sub@email.com"},
+		"sub@email.com":   {"user1@email.com"},
+		"other@email.com": {"user2@email.com"},
+	}})
+
+	cases := []struct {
+		name     string
+		expander *groups.Expander
+		want     []access
+	}{
+		{
+			name: "literal match",
+			want: []access{as[0]},
+		},
+		{
+			name:     "through nested groups",
+			expander: expander,
+			want: []access{
+				as[0],
+				{Project: "prj", Dataset: "ds2", Role: bq.WriterRole, EntityType: "group", Entity: "team@email.com", Source: "dataset", Via: []string{"team@email.com", "sub@email.com"}},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			matcher, err := newEntityMatcher("user1@email.com", false)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := accessOf(context.Background(), as, matcher, c.expander)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got: %+v\nwant: %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestCacheCreatedAt(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cache.toml")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(file, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name string
+		ms   metadata.Metas
+		want time.Time
+	}{
+		{name: "header", ms: metadata.Metas{Header: metadata.Header{CreatedAt: created}}, want: created},
+		{name: "no header", ms: metadata.Metas{}, want: modified},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := cacheCreatedAt(c.ms, file)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !got.Equal(c.want) {
+				t.Errorf("got: %v, want: %v", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix && !windows
+
+package cmd
+
+import "os/exec"
+
+// detaching is not supported, so the process may stop with the parent
+func detach(c *exec.Cmd) {}
@@ -0,0 +1,134 @@
+/*
+Copyright © 2020 Hirohito Sasakawa
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"github.com/spf13/cobra"
+
+	"github.com/hirosassa/bqiam/atomicfile"
+	"github.com/hirosassa/bqiam/bqrole"
+)
+
+func init() {
+	rootCmd.AddCommand(newExpireCmd())
+}
+
+func newExpireCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "expire",
+		Short: "Revokes the dataset grants whose deadline has passed",
+		Long: `expire revokes the dataset access granted by permit with --expires whose deadline has passed,
+as recorded in the expiry ledger file. Project roles granted with --expires expire by their IAM conditions.
+For example:
+
+bqiam expire -y
+`,
+		Args: cobra.NoArgs,
+		RunE: runExpireCmd,
+	}
+
+	cmd.Flags().BoolP("yes", "y", false, "Automatic yes to prompts")
+	cmd.Flags().Bool("dry-run", false, "Print the expired grants without revoking them")
+
+	return cmd
+}
+
+func runExpireCmd(cmd *cobra.Command, args []string) error {
+	yes, err := cmd.Flags().GetBool("yes")
+	if err != nil {
+		return fmt.Errorf("failed to parse yes flag: %s", err)
+	}
+
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return fmt.Errorf("failed to parse dry-run flag: %s", err)
+	}
+
+	if dryRun {
+		ledger, err := bqrole.LoadLedger(config.ExpiryLedgerFile)
+		if err != nil {
+			return err
+		}
+		due := ledger.Due(time.Now())
+		if len(due) == 0 {
+			fmt.Println("No expired grants.")
+		}
+		for _, g := range due {
+			fmt.Println(g)
+		}
+		return nil
+	}
+
+	return updateLedger(func(ledger *bqrole.Ledger) error {
+		return expire(ledger, yes)
+	})
+}
+
+// expire revokes the grants due in the ledger, and removes them from the ledger.
+func expire(ledger *bqrole.Ledger, yes bool) error {
+	now := time.Now()
+	due := ledger.Due(now)
+	if len(due) == 0 {
+		fmt.Println("No expired grants.")
+		return nil
+	}
+
+	ctx := context.Background()
+	client, err := bq.NewClient(ctx, due[0].Project)
+	if err != nil {
+		return fmt.Errorf("failed to create bigquery Client: %s", err)
+	}
+	defer client.Close()
+	acl := bqrole.NewBigQueryDatasetBackend(client)
+
+	// the revoked grants are removed from the ledger, even if some of them failed
+	if err := bqrole.Expire(ctx, acl, ledger, now, bqrole.Options{Yes: yes, Retry: config.UpdateRetry}); err != nil {
+		return fmt.Errorf("failed to expire: %s", err)
+	}
+	return nil
+}
+
+// ledgerExists reports whether the expiry ledger file exists, which is made by the first time-bound grant.
+func ledgerExists() bool {
+	_, err := os.Stat(config.ExpiryLedgerFile)
+	return err == nil
+}
+
+// updateLedger calls update with the expiry ledger, and saves it even if update fails.
+// The ledger file is locked from loading to saving, so that concurrent runs don't lose each other's grants.
+func updateLedger(update func(*bqrole.Ledger) error) error {
+	unlock, err := atomicfile.Lock(config.ExpiryLedgerFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	ledger, err := bqrole.LoadLedger(config.ExpiryLedgerFile)
+	if err != nil {
+		return err
+	}
+	updateErr := update(ledger)
+	if err := ledger.SaveLocked(config.ExpiryLedgerFile); err != nil {
+		return err
+	}
+	return updateErr
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	bq "cloud.google.com/go/bigquery"
+
+	"github.com/hirosassa/bqiam/bqrole"
+)
+
+func TestUpdateLedgerConcurrently(t *testing.T) {
+	prev := config
+	t.Cleanup(func() { config = prev })
+	config.ExpiryLedgerFile = filepath.Join(t.TempDir(), "ledger.toml")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := updateLedger(func(ledger *bqrole.Ledger) error {
+				ledger.Grants = append(ledger.Grants, bqrole.ExpiringGrant{
+					Project: "bq-project",
+					Dataset: fmt.Sprintf("dataset%d", i),
+					Role:    bq.ReaderRole,
+					Member:  "user1@email.com",
+				})
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	ledger, err := bqrole.LoadLedger(config.ExpiryLedgerFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ledger.Grants) != 10 {
+		t.Errorf("got %d grants, want 10 (lost updates): %v", len(ledger.Grants), ledger.Grants)
+	}
+}
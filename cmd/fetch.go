@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/api/googleapi"
+
+	"github.com/hirosassa/bqiam/bqrole"
+)
+
+// fetcher calls APIs under the QPS limit, retrying on rate limit and server errors.
+type fetcher struct {
+	limiter *rate.Limiter
+	retry   bqrole.RetryPolicy
+}
+
+// newFetcher returns a fetcher limited to qps calls per second, or unlimited if qps is not positive.
+func newFetcher(qps float64, retry bqrole.RetryPolicy) *fetcher {
+	limit := rate.Inf
+	if qps > 0 {
+		limit = rate.Limit(qps)
+	}
+	return &fetcher{limiter: rate.NewLimiter(limit, 1), retry: retry}
+}
+
+// do calls fn, waiting for the limiter before each attempt.
+func (f *fetcher) do(ctx context.Context, fn func() error) error {
+	return f.retry.Do(ctx, isRetryableAPIError, func() error {
+		if err := f.limiter.Wait(ctx); err != nil {
+			return err
+		}
+		return fn()
+	})
+}
+
+// isRetryableAPIError reports whether err is a rate limit error or a server error (5xx).
+// BigQuery reports rate limits as 403 with the reason rateLimitExceeded rather than 429.
+func isRetryableAPIError(err error) bool {
+	var e *googleapi.Error
+	if !errors.As(err, &e) {
+		return false
+	}
+	switch {
+	case e.Code == http.StatusTooManyRequests, e.Code >= http.StatusInternalServerError:
+		return true
+	case e.Code == http.StatusForbidden:
+		for _, item := range e.Errors {
+			if item.Reason == "rateLimitExceeded" || item.Reason == "userRateLimitExceeded" {
+				return true
+			}
+		}
+	}
+	return false
+}
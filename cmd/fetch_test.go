@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+
+	"github.com/hirosassa/bqiam/bqrole"
+)
+
+func TestFetcherDo(t *testing.T) {
+	cases := []struct {
+		name      string
+		err       error
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "success", err: nil, wantCalls: 1},
+		{name: "rate limited", err: &googleapi.Error{Code: http.StatusTooManyRequests}, wantCalls: 3, wantErr: true},
+		{
+			name:      "bigquery rate limited",
+			err:       &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}},
+			wantCalls: 3,
+			wantErr:   true,
+		},
+		{
+			name:      "permission denied",
+			err:       &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "accessDenied"}}},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		{name: "server error", err: fmt.Errorf("wrapped: %w", &googleapi.Error{Code: http.StatusServiceUnavailable}), wantCalls: 3, wantErr: true},
+		{name: "not found", err: &googleapi.Error{Code: http.StatusNotFound}, wantCalls: 1, wantErr: true},
+		{name: "other error", err: errors.New("unexpected"), wantCalls: 1, wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newFetcher(0, bqrole.RetryPolicy{MaxAttempts: 3})
+			calls := 0
+			err := f.do(context.Background(), func() error {
+				calls++
+				return c.err
+			})
+			if (err != nil) != c.wantErr {
+				t.Errorf("got err: %v, wantErr: %v", err, c.wantErr)
+			}
+			if calls != c.wantCalls {
+				t.Errorf("got calls: %d, want: %d", calls, c.wantCalls)
+			}
+		})
+	}
+}
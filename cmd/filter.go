@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	bq "cloud.google.com/go/bigquery"
+
+	"github.com/hirosassa/bqiam/bqrole"
+	"github.com/hirosassa/bqiam/metadata"
+)
+
+// entityMatcher matches the entities of access entries against the queried pattern.
+type entityMatcher struct {
+	exact string // the queried entity if the pattern is not a glob, regex or domain
+	match func(entity string) bool
+}
+
+// newEntityMatcher returns the matcher of pattern, which is taken as
+//   - a regular expression if regex is true, like ^svc-.*
+//   - an email domain if it starts with "@", like @email.com, which also matches the domain entity
+//   - a glob if it has any of "*?[", like *@email.com
+//   - the exact entity otherwise
+func newEntityMatcher(pattern string, regex bool) (entityMatcher, error) {
+	switch {
+	case regex:
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return entityMatcher{}, fmt.Errorf("invalid regular expression %s: %s", pattern, err)
+		}
+		return entityMatcher{match: re.MatchString}, nil
+	case strings.HasPrefix(pattern, "@"):
+		domain := strings.ToLower(pattern[1:])
+		return entityMatcher{match: func(entity string) bool {
+			entity = strings.ToLower(entity)
+			return entity == domain || strings.HasSuffix(entity, "@"+domain)
+		}}, nil
+	case strings.ContainsAny(pattern, "*?["):
+		if _, err := path.Match(pattern, ""); err != nil {
+			return entityMatcher{}, fmt.Errorf("invalid glob pattern %s: %s", pattern, err)
+		}
+		return entityMatcher{match: func(entity string) bool {
+			ok, _ := path.Match(pattern, entity)
+			return ok
+		}}, nil
+	}
+	return entityMatcher{exact: pattern, match: func(entity string) bool { return entity == pattern }}, nil
+}
+
+// accessFilter narrows down the access list. Empty fields match everything.
+type accessFilter struct {
+	projects      []string
+	datasetPrefix string
+	roles         []bq.AccessRole
+	entityTypes   []string
+}
+
+// cacheFilter returns the filter to load the entries f may match from SQLite caches.
+func (f accessFilter) cacheFilter() metadata.Filter {
+	return metadata.Filter{
+		Projects:      f.projects,
+		DatasetPrefix: f.datasetPrefix,
+		Roles:         f.roles,
+		EntityTypes:   f.entityTypes,
+		BindingRoles:  bqrole.ImpliedProjectRoles(f.roles...),
+	}
+}
+
+func (f accessFilter) matches(a access) bool {
+	if len(f.projects) > 0 && !contains(f.projects, a.Project) {
+		return false
+	}
+	if !strings.HasPrefix(a.Dataset, f.datasetPrefix) {
+		return false
+	}
+	if len(f.roles) > 0 && !contains(f.roles, a.Role) {
+		return false
+	}
+	if len(f.entityTypes) > 0 && !contains(f.entityTypes, a.EntityType) {
+		return false
+	}
+	return true
+}
+
+func contains[T comparable](s []T, v T) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"testing"
+
+	bq "cloud.google.com/go/bigquery"
+)
+
+func TestEntityMatcher(t *testing.T) {
+	cases := []struct {
+		name    string
+		pattern string
+		regex   bool
+		entity  string
+		want    bool
+		wantErr bool
+	}{
+		{name: "exact", pattern: "user1@email.com", entity: "user1@email.com", want: true},
+		{name: "exact mismatch", pattern: "user1@email.com", entity: "user10@email.com", want: false},
+		{name: "glob", pattern: "*@contractor.com", entity: "user1@contractor.com", want: true},
+		{name: "glob mismatch", pattern: "*@contractor.com", entity: "user1@email.com", want: false},
+		{name: "domain", pattern: "@contractor.com", entity: "User1@Contractor.com", want: true},
+		{name: "domain entity", pattern: "@contractor.com", entity: "contractor.com", want: true},
+		{name: "domain mismatch", pattern: "@contractor.com", entity: "user1@sub.contractor.com", want: false},
+		{name: "regex", pattern: "^svc-.*", regex: true, entity: "svc-batch@email.com", want: true},
+		{name: "regex mismatch", pattern: "^svc-.*", regex: true, entity: "user-svc@email.com", want: false},
+		{name: "invalid regex", pattern: "(", regex: true, wantErr: true},
+		{name: "invalid glob", pattern: "[", wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m, err := newEntityMatcher(c.pattern, c.regex)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("got err: %v, wantErr: %v", err, c.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := m.match(c.entity); got != c.want {
+				t.Errorf("got: %v, want: %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestAccessFilter(t *testing.T) {
+	a := access{Project: "prj", Dataset: "staging_ds", Role: bq.WriterRole, EntityType: "user", Entity: "user1@email.com"}
+
+	cases := []struct {
+		name   string
+		filter accessFilter
+		want   bool
+	}{
+		{name: "no filter", filter: accessFilter{}, want: true},
+		{name: "project", filter: accessFilter{projects: []string{"other", "prj"}}, want: true},
+		{name: "other project", filter: accessFilter{projects: []string{"other"}}, want: false},
+		{name: "dataset prefix", filter: accessFilter{datasetPrefix: "staging_"}, want: true},
+		{name: "other dataset prefix", filter: accessFilter{datasetPrefix: "prod_"}, want: false},
+		{name: "role", filter: accessFilter{roles: []bq.AccessRole{bq.WriterRole}}, want: true},
+		{name: "other role", filter: accessFilter{roles: []bq.AccessRole{bq.OwnerRole}}, want: false},
+		{name: "entity type", filter: accessFilter{entityTypes: []string{"group"}}, want: false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.filter.matches(a); got != c.want {
+				t.Errorf("got: %v, want: %v", got, c.want)
+			}
+		})
+	}
+}
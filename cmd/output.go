@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+var accessColumns = []string{"project", "dataset", "role", "entityType", "entity", "source", "via"}
+
+// writeAccess writes the access list in format, which is one of text, table, json, yaml, csv and tsv.
+// text is the plain space-separated form written by text for each access.
+func writeAccess(w io.Writer, format string, as []access, text func(io.Writer, access)) error {
+	switch format {
+	case "text":
+		for _, a := range as {
+			text(w, a)
+		}
+		return nil
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		var headers []string
+		for _, c := range accessColumns {
+			headers = append(headers, strings.ToUpper(c))
+		}
+		fmt.Fprintln(tw, strings.Join(headers, "\t"))
+		for _, a := range as {
+			fmt.Fprintln(tw, strings.Join(a.row(), "\t"))
+		}
+		return tw.Flush()
+	case "json":
+		if as == nil {
+			as = []access{} // write [] rather than null
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(as)
+	case "yaml":
+		if as == nil {
+			as = []access{}
+		}
+		enc := yaml.NewEncoder(w)
+		defer enc.Close()
+		return enc.Encode(as)
+	case "csv", "tsv":
+		cw := csv.NewWriter(w)
+		if format == "tsv" {
+			cw.Comma = '\t'
+		}
+		if err := cw.Write(accessColumns); err != nil {
+			return err
+		}
+		for _, a := range as {
+			if err := cw.Write(a.row()); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("unknown output format: %s (must be text, table, json, yaml, csv or tsv)", format)
+}
+
+// row returns the fields of a in the order of accessColumns.
+func (a access) row() []string {
+	return []string{a.Project, a.Dataset, string(a.Role), a.EntityType, a.Entity, a.Source, strings.Join(a.Via, ">")}
+}
+
+// writeRows writes the result of a query in format, which is one of table, json, csv and tsv.
+// NULL is written as an empty string except in json.
+func writeRows(w io.Writer, format string, columns []string, rows [][]any) error {
+	switch format {
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(columns, "\t"))
+		for _, r := range rows {
+			fmt.Fprintln(tw, strings.Join(formatRow(r), "\t"))
+		}
+		return tw.Flush()
+	case "json":
+		objs := make([]map[string]any, 0, len(rows))
+		for _, r := range rows {
+			obj := map[string]any{}
+			for i, c := range columns {
+				obj[c] = r[i]
+			}
+			objs = append(objs, obj)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(objs)
+	case "csv", "tsv":
+		cw := csv.NewWriter(w)
+		if format == "tsv" {
+			cw.Comma = '\t'
+		}
+		if err := cw.Write(columns); err != nil {
+			return err
+		}
+		for _, r := range rows {
+			if err := cw.Write(formatRow(r)); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("unknown output format: %s (must be table, json, csv or tsv)", format)
+}
+
+func formatRow(r []any) []string {
+	fields := make([]string, len(r))
+	for i, v := range r {
+		if v != nil {
+			fields[i] = fmt.Sprint(v)
+		}
+	}
+	return fields
+}
@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	bq "cloud.google.com/go/bigquery"
+)
+
+func TestWriteAccess(t *testing.T) {
+	as := []access{
+		{Project: "prj", Dataset: "ds1", Role: bq.ReaderRole, EntityType: "user", Entity: "user1@email.com", Source: "dataset"},
+		{Project: "prj", Dataset: "ds2", Role: bq.WriterRole, EntityType: "group", Entity: "team@email.com", Source: "dataset", Via: []string{"team@email.com", "sub@email.com"}},
+	}
+	text := func(w io.Writer, a access) { fmt.Fprintln(w, a.Project, a.Dataset, a.Role) }
+
+	cases := []struct {
+		format  string
+		as      []access
+		want    string
+		wantErr bool
+	}{
+		{
+			format: "text",
+			as:     as,
+			want:   "prj ds1 READER\nprj ds2 WRITER\n",
+		},
+		{
+			format: "table",
+			as:     as,
+			want: "PROJECT  DATASET  ROLE    ENTITYTYPE  ENTITY           SOURCE   VIA\n" +
+				"prj      ds1      READER  user        user1@email.com  dataset  \n" +
+				"prj      ds2      WRITER  group       team@email.com   dataset  team@email.com>sub@email.com\n",
+		},
+		{
+			format: "csv",
+			as:     as,
+			want: "project,dataset,role,entityType,entity,source,via\n" +
+				"prj,ds1,READER,user,user1@email.com,dataset,\n" +
+				"prj,ds2,WRITER,group,team@email.com,dataset,team@email.com>sub@email.com\n",
+		},
+		{
+			format: "tsv",
+			as:     as[:1],
+			want: "project\tdataset\trole\tentityType\tentity\tsource\tvia\n" +
+				"prj\tds1\tREADER\tuser\tuser1@email.com\tdataset\t\n",
+		},
+		{
+			format: "json",
+			as:     as[1:],
+			want: `[
+  {
+    "project": "prj",
+    "dataset": "ds2",
+    "role": "WRITER",
+    "entityType": "group",
+    "entity": "team@email.com",
+    "source": "dataset",
+    "via": [
+      "team@email.com",
+      "sub@email.com"
+    ]
+  }
+]
+`,
+		},
+		{
+			format: "json",
+			as:     nil,
+			want:   "[]\n",
+		},
+		{
+			format: "yaml",
+			as:     as[:1],
+			want: `- project: prj
+  dataset: ds1
+  role: READER
+  entityType: user
+  entity: user1@email.com
+  source: dataset
+`,
+		},
+		{
+			format:  "xml",
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := writeAccess(&buf, c.format, c.as, text)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("got err: %v, wantErr: %v", err, c.wantErr)
+			}
+			if got := buf.String(); got != c.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, c.want)
+			}
+		})
+	}
+}
+
+func TestWriteRows(t *testing.T) {
+	columns := []string{"entity", "n"}
+	rows := [][]any{{"user1@email.com", int64(2)}, {nil, int64(1)}}
+
+	cases := []struct {
+		format  string
+		want    string
+		wantErr bool
+	}{
+		{
+			format: "table",
+			want: "entity           n\n" +
+				"user1@email.com  2\n" +
+				"                 1\n",
+		},
+		{
+			format: "csv",
+			want:   "entity,n\nuser1@email.com,2\n,1\n",
+		},
+		{
+			format: "tsv",
+			want:   "entity\tn\nuser1@email.com\t2\n\t1\n",
+		},
+		{
+			format: "json",
+			want: `[
+  {
+    "entity": "user1@email.com",
+    "n": 2
+  },
+  {
+    "entity": null,
+    "n": 1
+  }
+]
+`,
+		},
+		{
+			format:  "yaml",
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := writeRows(&buf, c.format, columns, rows)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("got err: %v, wantErr: %v", err, c.wantErr)
+			}
+			if got := buf.String(); got != c.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, c.want)
+			}
+		})
+	}
+}
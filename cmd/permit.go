@@ -16,9 +16,13 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"os"
+	"time"
 
+	bq "cloud.google.com/go/bigquery"
 	"github.com/spf13/cobra"
 
 	"github.com/hirosassa/bqiam/bqrole"
@@ -40,6 +44,8 @@ For example:
 
 bqiam permit dataset READER -p bq-project-id -u user1@email.com -u user2@email.com -d dataset1 -d dataset2
 bqiam permit project READER -p bq-project-id -u user1@email.com
+bqiam permit dataset READER -p bq-project-id -u group:group1@email.com -u domain:email.com -d dataset1
+bqiam permit dataset READER -p bq-project-id -u user1@email.com -d dataset1 --expires 7d
 `,
 		Run: func(cmd *cobra.Command, args []string) {
 			_ = cmd.Help()
@@ -47,6 +53,10 @@ bqiam permit project READER -p bq-project-id -u user1@email.com
 	}
 
 	cmd.PersistentFlags().BoolP("yes", "y", false, "Automatic yes to prompts")
+	cmd.PersistentFlags().Bool("detect-member-type", false, "Retry user email(s) without member type as group account(s) when rejected")
+	cmd.PersistentFlags().Bool("dry-run", false, "Print the changes to be made against the current state without applying them")
+	cmd.PersistentFlags().StringP("output", "o", "text", "Output format of --dry-run (text | json)")
+	cmd.PersistentFlags().String("expires", "", "Grant only for the duration, like 7d, 2w or 12h (run `bqiam expire` to revoke expired dataset grants)")
 	cmd.AddCommand(
 		newPermitProjectCmd(),
 		newPermitDatasetCmd(),
@@ -73,7 +83,7 @@ bqiam permit project READER -p bq-project-id -u user1@email.com -u user2@email.c
 		panic(err)
 	}
 
-	cmd.Flags().StringSliceP("users", "u", []string{}, "Specify user email(s)")
+	cmd.Flags().StringSliceP("users", "u", []string{}, "Specify user email(s), or member(s) prefixed with user:, group:, serviceAccount: or domain:")
 
 	_ = registerProjectsCompletions(cmd)
 	_ = registerUsersCompletions(cmd)
@@ -101,12 +111,39 @@ func runPermitProjectCmd(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to parse users flag: %s", err)
 	}
 
-	yes, err := cmd.Flags().GetBool("yes")
+	members, err := bqrole.ParseMembers(users)
 	if err != nil {
-		return fmt.Errorf("failed to parse yes flag: %s", err)
+		return fmt.Errorf("failed to parse users flag: %s", err)
 	}
 
-	err = bqrole.PermitProject(role, project, users, yes)
+	opts, err := newOptions(cmd)
+	if err != nil {
+		return err
+	}
+	if opts.Expires, err = parseExpires(cmd); err != nil {
+		return err
+	}
+
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return fmt.Errorf("failed to parse dry-run flag: %s", err)
+	}
+
+	ctx := context.Background()
+	iam, err := bqrole.NewResourceManagerBackend(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create IAM client: %s", err)
+	}
+
+	if dryRun {
+		plan, err := bqrole.PlanPermitProject(ctx, iam, role, project, members, opts)
+		if err != nil {
+			return fmt.Errorf("failed to plan: %s", err)
+		}
+		return printPlan(cmd, plan)
+	}
+
+	err = bqrole.PermitProject(ctx, iam, role, project, members, opts)
 	if err != nil {
 		return fmt.Errorf("failed to permit: %s", err)
 	}
@@ -132,7 +169,7 @@ bqiam permit dataset READER -p bq-project-id -u user1@email.com -u user2@email.c
 		panic(err)
 	}
 
-	cmd.Flags().StringSliceP("users", "u", []string{}, "Specify user email(s)")
+	cmd.Flags().StringSliceP("users", "u", []string{}, "Specify user email(s), or member(s) prefixed with user:, group:, serviceAccount: or domain:")
 	cmd.Flags().StringSliceP("datasets", "d", []string{}, "Specify dataset(s)")
 
 	_ = registerProjectsCompletions(cmd)
@@ -162,20 +199,112 @@ func runPermitDatasetCmd(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to parse users flag: %s", err)
 	}
 
+	members, err := bqrole.ParseMembers(users)
+	if err != nil {
+		return fmt.Errorf("failed to parse users flag: %s", err)
+	}
+
 	datasets, err := cmd.Flags().GetStringSlice("datasets")
 	if err != nil {
 		return fmt.Errorf("failed to parse datasets flag: %s", err)
 	}
 
+	opts, err := newOptions(cmd)
+	if err != nil {
+		return err
+	}
+	if opts.Expires, err = parseExpires(cmd); err != nil {
+		return err
+	}
+
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return fmt.Errorf("failed to parse dry-run flag: %s", err)
+	}
+
+	ctx := context.Background()
+	iam, err := bqrole.NewResourceManagerBackend(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create IAM client: %s", err)
+	}
+
+	client, err := bq.NewClient(ctx, project)
+	if err != nil {
+		return fmt.Errorf("failed to create bigquery Client: %s", err)
+	}
+	defer client.Close()
+	acl := bqrole.NewBigQueryDatasetBackend(client)
+
+	if dryRun {
+		plan, err := bqrole.PlanPermitDataset(ctx, iam, acl, role, project, members, datasets, opts)
+		if err != nil {
+			return fmt.Errorf("failed to plan: %s", err)
+		}
+		return printPlan(cmd, plan)
+	}
+
+	if opts.Expires.IsZero() && !ledgerExists() {
+		err = bqrole.PermitDataset(ctx, iam, acl, role, project, members, datasets, opts)
+		if err != nil {
+			return fmt.Errorf("failed to permit: %s", err)
+		}
+		return nil
+	}
+
+	// record the time-bound grants, or remove the grants made permanent from the ledger, even if some of them failed
+	return updateLedger(func(ledger *bqrole.Ledger) error {
+		opts.Ledger = ledger
+		if err := bqrole.PermitDataset(ctx, iam, acl, role, project, members, datasets, opts); err != nil {
+			return fmt.Errorf("failed to permit: %s", err)
+		}
+		return nil
+	})
+}
+
+// newOptions builds bqrole.Options from the flags and the config.
+func newOptions(cmd *cobra.Command) (bqrole.Options, error) {
 	yes, err := cmd.Flags().GetBool("yes")
 	if err != nil {
-		return fmt.Errorf("failed to parse yes flag: %s", err)
+		return bqrole.Options{}, fmt.Errorf("failed to parse yes flag: %s", err)
 	}
 
-	err = bqrole.PermitDataset(role, project, users, datasets, yes)
+	detect, err := cmd.Flags().GetBool("detect-member-type")
 	if err != nil {
-		return fmt.Errorf("failed to permit: %s", err)
+		return bqrole.Options{}, fmt.Errorf("failed to parse detect-member-type flag: %s", err)
 	}
 
-	return nil
+	return bqrole.Options{
+		Yes:              yes,
+		Retry:            config.UpdateRetry,
+		DetectMemberType: detect,
+	}, nil
+}
+
+// parseExpires returns the deadline given by the expires flag, or the zero time for permanent grants.
+func parseExpires(cmd *cobra.Command) (time.Time, error) {
+	expires, err := cmd.Flags().GetString("expires")
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse expires flag: %s", err)
+	}
+	if expires == "" {
+		return time.Time{}, nil
+	}
+	return bqrole.ParseExpires(expires, time.Now())
+}
+
+// printPlan writes the plan to stdout in the format given by the output flag.
+func printPlan(cmd *cobra.Command, plan *bqrole.Plan) error {
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return fmt.Errorf("failed to parse output flag: %s", err)
+	}
+
+	switch output {
+	case "text":
+		plan.Print(os.Stdout)
+		return nil
+	case "json":
+		return plan.WriteJSON(os.Stdout)
+	}
+	return fmt.Errorf("unknown output format: %s (must be text or json)", output)
 }
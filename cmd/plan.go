@@ -0,0 +1,112 @@
+/*
+Copyright © 2020 Hirohito Sasakawa
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	bq "cloud.google.com/go/bigquery"
+	"github.com/spf13/cobra"
+
+	"github.com/hirosassa/bqiam/bqrole"
+)
+
+func init() {
+	rootCmd.AddCommand(newPlanCmd())
+}
+
+func newPlanCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "plan -f [access policy file (required)]",
+		Short: "shows the changes to make the access match the access policy file",
+		Long: `plan diffs the access policy file against the current project policies and dataset access lists,
+and prints the changes apply would make.
+For example:
+
+bqiam plan -f access.yaml
+bqiam plan -f access.yaml --prune -o json`,
+		RunE: runPlanCmd,
+	}
+
+	cmd.Flags().StringP("file", "f", "", "Specify access policy file")
+	err := cmd.MarkFlagRequired("file")
+	if err != nil {
+		panic(err)
+	}
+
+	cmd.Flags().Bool("prune", false, "Also remove members of the declared roles and datasets that are not in the file")
+	cmd.Flags().StringP("output", "o", "text", "Output format (text | json)")
+
+	return cmd
+}
+
+func runPlanCmd(cmd *cobra.Command, args []string) error {
+	spec, prune, err := parseSpecFlags(cmd)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	iam, acl, closeFn, err := newSpecBackends(ctx, spec)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	plan, err := bqrole.PlanSpec(ctx, iam, acl, spec, prune, bqrole.Options{Retry: config.UpdateRetry})
+	if err != nil {
+		return fmt.Errorf("failed to plan: %s", err)
+	}
+	return printPlan(cmd, plan)
+}
+
+// parseSpecFlags loads the access policy file given by the file flag.
+func parseSpecFlags(cmd *cobra.Command) (*bqrole.Spec, bool, error) {
+	file, err := cmd.Flags().GetString("file")
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to parse file flag: %s", err)
+	}
+
+	prune, err := cmd.Flags().GetBool("prune")
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to parse prune flag: %s", err)
+	}
+
+	spec, err := bqrole.LoadSpec(file)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(spec.Projects) == 0 {
+		return nil, false, errors.New("no projects in the access policy file")
+	}
+	return spec, prune, nil
+}
+
+// newSpecBackends creates the IAM and dataset backends for the projects in spec.
+func newSpecBackends(ctx context.Context, spec *bqrole.Spec) (bqrole.IAMBackend, bqrole.DatasetBackend, func(), error) {
+	iam, err := bqrole.NewResourceManagerBackend(ctx)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to create IAM client: %s", err)
+	}
+
+	client, err := bq.NewClient(ctx, spec.Projects[0].Project)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to create bigquery Client: %s", err)
+	}
+	return iam, bqrole.NewBigQueryDatasetBackend(client), func() { client.Close() }, nil
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// policies to refresh an expired cache
+const (
+	refreshAuto   = "auto"   // refresh in the background and use the expired cache
+	refreshNever  = "never"  // use the expired cache
+	refreshPrompt = "prompt" // ask whether to refresh
+	refreshAlways = "always" // refresh before every run, even if not expired
+)
+
+var refreshPolicies = []string{refreshAuto, refreshNever, refreshPrompt, refreshAlways}
+
+// refresh actions for a run
+const (
+	refreshNone = iota
+	refreshForeground
+	refreshBackground
+	refreshAsk
+)
+
+// refreshAction returns how to refresh the cache by policy.
+func refreshAction(policy string, expired bool) int {
+	switch {
+	case policy == refreshAlways:
+		return refreshForeground
+	case !expired || policy == refreshNever:
+		return refreshNone
+	case policy == refreshAuto:
+		return refreshBackground
+	}
+	return refreshAsk
+}
+
+// background refreshes running for less than this are not started again
+const backgroundRefreshTTL = 30 * time.Minute
+
+// startBackgroundRefresh runs `bqiam cache` detached from this process, logging to the returned file.
+// It doesn't start another one while the last one is running.
+func startBackgroundRefresh() (string, error) {
+	sum := sha256.Sum256([]byte(config.CacheFile))
+	logFile := filepath.Join(os.TempDir(), fmt.Sprintf("bqiam-refresh-%x.log", sum[:8]))
+	if info, err := os.Stat(logFile); err == nil && time.Since(info.ModTime()) < backgroundRefreshTTL {
+		return logFile, nil
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to find bqiam executable: %s", err)
+	}
+
+	log, err := os.Create(logFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to create log file: %s", err)
+	}
+	defer log.Close()
+
+	args := []string{"cache"}
+	if cfgFile != "" {
+		args = append(args, "--config", cfgFile)
+	}
+	c := exec.Command(exe, args...)
+	c.Stdout = log
+	c.Stderr = log
+	detach(c)
+	if err := c.Start(); err != nil {
+		return "", fmt.Errorf("failed to start bqiam cache: %s", err)
+	}
+	return logFile, c.Process.Release()
+}
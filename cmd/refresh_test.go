@@ -0,0 +1,25 @@
+package cmd
+
+import "testing"
+
+func TestRefreshAction(t *testing.T) {
+	cases := []struct {
+		policy  string
+		expired bool
+		want    int
+	}{
+		{policy: refreshAuto, expired: true, want: refreshBackground},
+		{policy: refreshAuto, expired: false, want: refreshNone},
+		{policy: refreshNever, expired: true, want: refreshNone},
+		{policy: refreshPrompt, expired: true, want: refreshAsk},
+		{policy: refreshPrompt, expired: false, want: refreshNone},
+		{policy: refreshAlways, expired: false, want: refreshForeground},
+		{policy: refreshAlways, expired: true, want: refreshForeground},
+	}
+
+	for _, c := range cases {
+		if got := refreshAction(c.policy, c.expired); got != c.want {
+			t.Errorf("refreshAction(%s, %v) got: %d, want: %d", c.policy, c.expired, got, c.want)
+		}
+	}
+}
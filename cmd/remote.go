@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/hirosassa/bqiam/blob"
+	"github.com/hirosassa/bqiam/metadata"
+)
+
+// metadata keys of the cache object, for checking the staleness without downloading it
+const (
+	objectCreatedAtKey = "bqiam-created-at"
+	objectVersionKey   = "bqiam-version"
+)
+
+// loadCacheFile loads the cache at location, a file path or an object URL, in format.
+func loadCacheFile(ctx context.Context, location, format string, ms *metadata.Metas) error {
+	s, err := metadata.NewStorage(format)
+	if err != nil {
+		return err
+	}
+	return withCacheFile(ctx, location, func(file string) error { return s.Load(file, ms) })
+}
+
+// withCacheFile calls load with the path of the cache at location, downloading it first if it's an object URL.
+func withCacheFile(ctx context.Context, location string, load func(file string) error) error {
+	if !blob.IsURL(location) {
+		return load(location)
+	}
+
+	file, cleanup, err := downloadCache(ctx, location)
+	if err != nil {
+		return fmt.Errorf("failed to load medadata cache file: %v\n  (use `bqiam cache` to create or update bigquery datasts' metadata)", err)
+	}
+	defer cleanup()
+	return load(file)
+}
+
+// saveCacheFile saves the cache to location, a file path or an object URL, in format.
+func saveCacheFile(ctx context.Context, location, format string, ms *metadata.Metas) error {
+	s, err := metadata.NewStorage(format)
+	if err != nil {
+		return err
+	}
+	if !blob.IsURL(location) {
+		return s.Save(location, ms)
+	}
+
+	dir, err := os.MkdirTemp("", "bqiam-cache-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, path.Base(location))
+	if err := s.Save(file, ms); err != nil {
+		return err
+	}
+	return uploadCache(ctx, location, file, ms.Header)
+}
+
+// downloadCache copies the cache object to a temp file, which cleanup removes.
+func downloadCache(ctx context.Context, location string) (string, func(), error) {
+	b, name, err := blob.Open(ctx, location)
+	if err != nil {
+		return "", nil, err
+	}
+
+	r, err := b.NewReader(ctx, name)
+	if err != nil {
+		return "", nil, err
+	}
+	defer r.Close()
+
+	dir, err := os.MkdirTemp("", "bqiam-cache-")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temp directory: %s", err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	file := filepath.Join(dir, path.Base(name))
+	f, err := os.Create(file)
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to download %s: %s", location, err)
+	}
+	if err := f.Close(); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return file, cleanup, nil
+}
+
+// uploadCache replaces the cache object with the file, recording the header in the object metadata.
+func uploadCache(ctx context.Context, location, file string, h metadata.Header) error {
+	b, name, err := blob.Open(ctx, location)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return b.Write(ctx, name, f, map[string]string{
+		objectCreatedAtKey: h.CreatedAt.Format(time.RFC3339),
+		objectVersionKey:   h.Version,
+	})
+}
+
+// cacheObjectCreatedAt returns when the cache object is created, from its metadata,
+// or when it's written if it's uploaded by other tools.
+func cacheObjectCreatedAt(ctx context.Context, location string) (time.Time, error) {
+	b, name, err := blob.Open(ctx, location)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	attrs, err := b.Attrs(ctx, name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if t, err := time.Parse(time.RFC3339, attrs.Metadata[objectCreatedAtKey]); err == nil {
+		return t, nil
+	}
+	return attrs.Updated, nil
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+
+	"github.com/hirosassa/bqiam/metadata"
+)
+
+func TestRemoteCache(t *testing.T) {
+	ctx := context.Background()
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ms := metadata.Metas{
+		Header: metadata.Header{CreatedAt: created, Version: "v1.0.0"},
+		Metas:  []metadata.Meta{{Project: "prj1", Dataset: "ds1", Role: bq.ReaderRole, EntityType: "user", Entity: "user1@email.com"}},
+	}
+
+	for _, format := range []string{metadata.FormatJSONGzip, metadata.FormatSQLite} {
+		t.Run(format, func(t *testing.T) {
+			location := "file://" + t.TempDir() + "/cache"
+
+			var missing metadata.Metas
+			if err := loadCacheFile(ctx, location, format, &missing); err == nil {
+				t.Error("expected an error on loading a missing cache")
+			}
+
+			if err := saveCacheFile(ctx, location, format, &ms); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var got metadata.Metas
+			if err := loadCacheFile(ctx, location, format, &got); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, ms) {
+				t.Errorf("got: %+v\nwant: %+v", got, ms)
+			}
+
+			createdAt, err := cacheCreatedAt(metadata.Metas{}, location)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !createdAt.Equal(created) {
+				t.Errorf("created at got: %v, want: %v", createdAt, created)
+			}
+		})
+	}
+}
@@ -16,9 +16,11 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 
+	bq "cloud.google.com/go/bigquery"
 	"github.com/spf13/cobra"
 
 	"github.com/hirosassa/bqiam/bqrole"
@@ -37,6 +39,7 @@ For example:
 
 bqiam revoke dataset READER -p bq-project-id -u user1@email.com -u user2@email.com -d dataset1 -d dataset2
 bqiam revoke project READER -p bq-project-id -u user1@email.com
+bqiam revoke dataset READER -p bq-project-id -u group:group1@email.com -u domain:email.com -d dataset1
 `,
 		Run: func(cmd *cobra.Command, args []string) {
 			_ = cmd.Help()
@@ -44,6 +47,9 @@ bqiam revoke project READER -p bq-project-id -u user1@email.com
 	}
 
 	cmd.PersistentFlags().BoolP("yes", "y", false, "Automatic yes to prompts")
+	cmd.PersistentFlags().Bool("detect-member-type", false, "Retry user email(s) without member type as group account(s) when rejected")
+	cmd.PersistentFlags().Bool("dry-run", false, "Print the changes to be made against the current state without applying them")
+	cmd.PersistentFlags().StringP("output", "o", "text", "Output format of --dry-run (text | json)")
 	cmd.AddCommand(
 		newRevokeDatasetCmd(),
 		newRevokeProjectCmd(),
@@ -69,7 +75,7 @@ bqiam project READER -p bq-project-id -u user1@email.com -u user2@email.com`,
 		panic(err)
 	}
 
-	cmd.Flags().StringSliceP("users", "u", []string{}, "Specify user email(s)")
+	cmd.Flags().StringSliceP("users", "u", []string{}, "Specify user email(s), or member(s) prefixed with user:, group:, serviceAccount: or domain:")
 
 	_ = registerProjectsCompletions(cmd)
 	_ = registerUsersCompletions(cmd)
@@ -97,12 +103,36 @@ func runRevokeProjectCmd(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to parse users flag: %s", err)
 	}
 
-	yes, err := cmd.Flags().GetBool("yes")
+	members, err := bqrole.ParseMembers(users)
 	if err != nil {
-		return fmt.Errorf("failed to parse yes flag: %s", err)
+		return fmt.Errorf("failed to parse users flag: %s", err)
+	}
+
+	opts, err := newOptions(cmd)
+	if err != nil {
+		return err
+	}
+
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return fmt.Errorf("failed to parse dry-run flag: %s", err)
+	}
+
+	ctx := context.Background()
+	iam, err := bqrole.NewResourceManagerBackend(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create IAM client: %s", err)
+	}
+
+	if dryRun {
+		plan, err := bqrole.PlanRevokeProject(ctx, iam, role, project, members, opts)
+		if err != nil {
+			return fmt.Errorf("failed to plan: %s", err)
+		}
+		return printPlan(cmd, plan)
 	}
 
-	err = bqrole.RevokeProject(role, project, users, yes)
+	err = bqrole.RevokeProject(ctx, iam, role, project, members, opts)
 	if err != nil {
 		return fmt.Errorf("failed to revoke: %s", err)
 	}
@@ -127,7 +157,7 @@ bqiam dataset READER -p bq-project-id -u user1@email.com -u user2@email.com -d d
 		panic(err)
 	}
 
-	cmd.Flags().StringSliceP("users", "u", []string{}, "Specify user email(s)")
+	cmd.Flags().StringSliceP("users", "u", []string{}, "Specify user email(s), or member(s) prefixed with user:, group:, serviceAccount: or domain:")
 	cmd.Flags().StringSliceP("datasets", "d", []string{}, "Specify dataset(s)")
 
 	_ = registerProjectsCompletions(cmd)
@@ -157,17 +187,43 @@ func runRevokeDatasetCmd(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to parse users flag: %s", err)
 	}
 
+	members, err := bqrole.ParseMembers(users)
+	if err != nil {
+		return fmt.Errorf("failed to parse users flag: %s", err)
+	}
+
 	datasets, err := cmd.Flags().GetStringSlice("datasets")
 	if err != nil {
 		return fmt.Errorf("failed to parse datasets flag: %s", err)
 	}
 
-	yes, err := cmd.Flags().GetBool("yes")
+	opts, err := newOptions(cmd)
+	if err != nil {
+		return err
+	}
+
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return fmt.Errorf("failed to parse dry-run flag: %s", err)
+	}
+
+	ctx := context.Background()
+	client, err := bq.NewClient(ctx, project)
 	if err != nil {
-		return fmt.Errorf("failed to parse yes flag: %s", err)
+		return fmt.Errorf("failed to create bigquery Client: %s", err)
+	}
+	defer client.Close()
+	acl := bqrole.NewBigQueryDatasetBackend(client)
+
+	if dryRun {
+		plan, err := bqrole.PlanRevokeDataset(ctx, acl, role, project, members, datasets, opts)
+		if err != nil {
+			return fmt.Errorf("failed to plan: %s", err)
+		}
+		return printPlan(cmd, plan)
 	}
 
-	err = bqrole.RevokeDataset(role, project, users, datasets, yes)
+	err = bqrole.RevokeDataset(ctx, acl, role, project, members, datasets, opts)
 	if err != nil {
 		return fmt.Errorf("failed to revoke: %s", err)
 	}
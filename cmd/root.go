@@ -11,6 +11,8 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+
+	"github.com/hirosassa/bqiam/bqrole"
 )
 
 var cfgFile string
@@ -19,13 +21,23 @@ var config Config
 
 type Config struct {
 	BigqueryProjects   []string
-	CacheFile          string
+	CacheFile          string // file path, or object URL (gs://bucket/name) of a cache shared by a team
 	CacheRefreshHour   int
+	CacheRefreshPolicy string // auto, never, prompt or always
 	CompletionFilePath string
+	UpdateRetry        bqrole.RetryPolicy
+	GroupsFile         string  // resolve group members from the file instead of Cloud Identity if set
+	CacheConcurrency   int     // number of concurrent API calls of the cache command
+	CacheQPS           float64 // max API calls per second of the cache command, unlimited if 0
+	CacheRetry         bqrole.RetryPolicy
+	ExpiryLedgerFile   string // records the time-bound dataset grants to be revoked by `bqiam expire`
+	CacheBackend       string // format of the cache file, "toml", "json", "json.gz" or "sqlite"; from the file extension if empty
 }
 
 var verbose, debug bool // for verbose and debug output
 
+var version = "dev" // bqiam version, recorded in the cache
+
 // rootCmd represents the root command
 var rootCmd = &cobra.Command{
 	Use:   "bqiam",
@@ -35,6 +47,12 @@ This tool provides easier IAM management functionalities
 `,
 }
 
+// SetVersion sets the version and the revision of bqiam, shown by --version.
+func SetVersion(v, revision string) {
+	version = v
+	rootCmd.Version = fmt.Sprintf("%s (rev: %s)", v, revision)
+}
+
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
@@ -60,7 +78,24 @@ func initConfig() {
 		viper.SetDefault("CompletionFilePath", path.Join(home, ".bqiam-completion-file.toml"))
 	}
 
+	viper.SetDefault("UpdateRetry.MaxAttempts", bqrole.DefaultRetryPolicy.MaxAttempts)
+	viper.SetDefault("UpdateRetry.InitialBackoff", bqrole.DefaultRetryPolicy.InitialBackoff)
+	viper.SetDefault("UpdateRetry.MaxBackoff", bqrole.DefaultRetryPolicy.MaxBackoff)
+
+	viper.SetDefault("CacheConcurrency", 8)
+	viper.SetDefault("CacheQPS", 10)
+	viper.SetDefault("CacheRetry.MaxAttempts", bqrole.DefaultRetryPolicy.MaxAttempts)
+	viper.SetDefault("CacheRetry.InitialBackoff", bqrole.DefaultRetryPolicy.InitialBackoff)
+	viper.SetDefault("CacheRetry.MaxBackoff", bqrole.DefaultRetryPolicy.MaxBackoff)
+
+	viper.SetDefault("CacheRefreshPolicy", refreshPrompt)
+	viper.SetDefault("ExpiryLedgerFile", "~/.bqiam-expiry-ledger.toml")
+
 	viper.AutomaticEnv() // read in environment variables that match
+	if err := viper.BindEnv("CacheRefreshPolicy", "BQIAM_REFRESH"); err != nil {
+		fmt.Println("Failed to bind env 'BQIAM_REFRESH': ", err)
+		os.Exit(1)
+	}
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err != nil {
@@ -87,6 +122,25 @@ func initConfig() {
 	}
 	config.CompletionFilePath = realCompletionFilePath
 
+	realGroupsFile, err := realPath(config.GroupsFile)
+	if err != nil {
+		fmt.Println("Failed to expand Groups File Path:", config.GroupsFile)
+		os.Exit(1)
+	}
+	config.GroupsFile = realGroupsFile
+
+	realExpiryLedgerFile, err := realPath(config.ExpiryLedgerFile)
+	if err != nil {
+		fmt.Println("Failed to expand Expiry Ledger File Path:", config.ExpiryLedgerFile)
+		os.Exit(1)
+	}
+	config.ExpiryLedgerFile = realExpiryLedgerFile
+
+	if !contains(refreshPolicies, config.CacheRefreshPolicy) {
+		fmt.Printf("Invalid refresh policy: %s (must be one of %s)\n", config.CacheRefreshPolicy, strings.Join(refreshPolicies, ", "))
+		os.Exit(1)
+	}
+
 	logOutput() // set log level
 }
 
@@ -118,13 +172,20 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.bqiam.toml)")
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 
-	rootCmd.PersistentFlags().IntP("refresh", "r", 24, "cache refresh threshold in hour (default is 24 hours)")
+	rootCmd.PersistentFlags().IntP("refresh-hour", "r", 24, "cache refresh threshold in hour (default is 24 hours)")
+	rootCmd.PersistentFlags().String("refresh", refreshPrompt, "how to refresh an old cache (auto | never | prompt | always), also set by BQIAM_REFRESH")
 
 	// for log output
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "enable varbose log output")
 	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "enable debug log output")
 
-	err := viper.BindPFlag("CacheRefreshHour", rootCmd.PersistentFlags().Lookup("refresh")) // overwrite by flag if exists
+	err := viper.BindPFlag("CacheRefreshHour", rootCmd.PersistentFlags().Lookup("refresh-hour")) // overwrite by flag if exists
+	if err != nil {
+		fmt.Println("Failed to bind flag 'refresh-hour': ", err)
+		os.Exit(1)
+	}
+
+	err = viper.BindPFlag("CacheRefreshPolicy", rootCmd.PersistentFlags().Lookup("refresh"))
 	if err != nil {
 		fmt.Println("Failed to bind flag 'refresh': ", err)
 		os.Exit(1)
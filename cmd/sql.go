@@ -0,0 +1,81 @@
+/*
+Copyright © 2020 Hirohito Sasakawa
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hirosassa/bqiam/blob"
+	"github.com/hirosassa/bqiam/metadata"
+)
+
+// sqlCmd represents the sql command
+var sqlCmd = &cobra.Command{
+	Use:   "sql [query (required)]",
+	Short: "Run a SQL query against the SQLite cache",
+	Long: `
+This subcommand runs a read-only SQL query against the cache,
+which requires the SQLite cache (a CacheFile ending with .db, or CacheBackend = "sqlite").
+The cache has the tables acl, bindings, datasets, projects and header.
+For example:
+
+bqiam sql "SELECT project, dataset FROM acl WHERE role = 'WRITER' AND entity LIKE '%@contractor.com'"
+`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("query is required")
+		}
+		return nil
+	},
+	RunE: runCmdSQL,
+}
+
+func runCmdSQL(cmd *cobra.Command, args []string) error {
+	if cacheFormat() != metadata.FormatSQLite {
+		return fmt.Errorf("sql requires the SQLite cache (set CacheBackend = %q in the config file and run `bqiam cache`, or use `bqiam cache convert`)", metadata.FormatSQLite)
+	}
+
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return fmt.Errorf("failed to parse output flag: %s", err)
+	}
+
+	file := config.CacheFile
+	if blob.IsURL(file) {
+		downloaded, cleanup, err := downloadCache(context.Background(), file)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		file = downloaded
+	}
+
+	columns, rows, err := metadata.QuerySQLite(file, args[0])
+	if err != nil {
+		return err
+	}
+	return writeRows(os.Stdout, output, columns, rows)
+}
+
+func init() {
+	sqlCmd.Flags().StringP("output", "o", "table", "Output format (table | json | csv | tsv)")
+	rootCmd.AddCommand(sqlCmd)
+}
@@ -0,0 +1,99 @@
+/*
+Copyright © 2020 Hirohito Sasakawa
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hirosassa/bqiam/bqrole"
+	"github.com/hirosassa/bqiam/metadata"
+)
+
+// whoCmd represents the who command
+var whoCmd = &cobra.Command{
+	Use:   "who [project.dataset (required)]",
+	Short: "List principals that have permissions on the input dataset",
+	Long: `
+This subcommand returns a list of entities that are able to access the input dataset,
+from the dataset access entries and the project-level IAM roles in the cache
+that implicitly grant access to the datasets of the project.
+For example:
+
+bqiam who bq-project-id.dataset1
+`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("project.dataset is required")
+		}
+		return nil
+	},
+	RunE: runCmdWho,
+}
+
+func runCmdWho(cmd *cobra.Command, args []string) error {
+	project, dataset, ok := strings.Cut(args[0], ".")
+	if !ok || project == "" || dataset == "" {
+		return fmt.Errorf("dataset must be specified as project.dataset: %s", args[0])
+	}
+
+	ms, err := loadCache(cmd, metadata.Filter{
+		Projects:     []string{project},
+		Dataset:      dataset,
+		BindingRoles: bqrole.ImpliedProjectRoles(),
+	}) // refresh cache if needed
+	if err != nil {
+		return err
+	}
+	warnCacheScope(ms, []string{project})
+
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return fmt.Errorf("failed to parse output flag: %s", err)
+	}
+
+	var as []access
+	for _, a := range effectiveAccess(ms) {
+		if a.Project == project && a.Dataset == dataset {
+			as = append(as, a)
+		}
+	}
+	if len(as) == 0 {
+		return fmt.Errorf("dataset %s.%s is not found in the cache (use `bqiam cache` to update)", project, dataset)
+	}
+
+	return writeAccess(os.Stdout, output, as, func(w io.Writer, a access) {
+		fmt.Fprintln(w, a.Role, a.EntityType+":"+a.Entity, a.Source)
+	})
+}
+
+// entityTypeOf returns the entity type of the cached entry, which is missing in caches made by older versions.
+func entityTypeOf(m metadata.Meta) string {
+	if m.EntityType == "" {
+		return "unknown"
+	}
+	return m.EntityType
+}
+
+func init() {
+	whoCmd.Flags().StringP("output", "o", "text", "Output format (text | table | json | yaml | csv | tsv)")
+	rootCmd.AddCommand(whoCmd)
+}
@@ -2,9 +2,8 @@ package completion
 
 import (
 	"fmt"
-	"os"
 
-	"github.com/BurntSushi/toml"
+	"github.com/hirosassa/bqiam/metadata"
 )
 
 type List struct {
@@ -15,19 +14,26 @@ type List struct {
 	DisplaySizeLimit int `toml:"DisplaySizeLimit"`
 }
 
+// Load reads the file in the format of its extension.
 func (l *List) Load(file string) error {
-	if _, err := toml.DecodeFile(file, l); err != nil {
+	codec, err := metadata.NewCodec(metadata.FormatOf(file))
+	if err != nil {
+		return fmt.Errorf("failed to load completion list file. %v", err)
+	}
+	if err := metadata.ReadFile(file, codec, l); err != nil {
 		return fmt.Errorf("failed to load completion list file. %v", err)
 	}
 	return nil
 }
 
+// Save stores the list to the file atomically, in the format of its extension.
 func (l *List) Save(file string) error {
-	f, err := os.Create(file)
+	codec, err := metadata.NewCodec(metadata.FormatOf(file))
 	if err != nil {
 		return fmt.Errorf("failed to save completion list to the file. err: %s", err)
 	}
-	defer f.Close()
-
-	return toml.NewEncoder(f).Encode(l)
+	if err := metadata.WriteFile(file, codec, l); err != nil {
+		return fmt.Errorf("failed to save completion list to the file. err: %s", err)
+	}
+	return nil
 }
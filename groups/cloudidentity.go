@@ -0,0 +1,43 @@
+package groups
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/api/cloudidentity/v1"
+)
+
+// CloudIdentityResolver resolves group members with the Cloud Identity Groups API.
+type CloudIdentityResolver struct {
+	service *cloudidentity.Service
+}
+
+func NewCloudIdentityResolver(ctx context.Context) (*CloudIdentityResolver, error) {
+	service, err := cloudidentity.NewService(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cloudidentity service: %s", err)
+	}
+	return &CloudIdentityResolver{service: service}, nil
+}
+
+func (r *CloudIdentityResolver) Members(ctx context.Context, group string) ([]Member, error) {
+	g, err := r.service.Groups.Lookup().GroupKeyId(group).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up group %s: %s", group, err)
+	}
+
+	var members []Member
+	err = r.service.Groups.Memberships.List(g.Name).Pages(ctx, func(res *cloudidentity.ListMembershipsResponse) error {
+		for _, m := range res.Memberships {
+			if m.PreferredMemberKey == nil {
+				continue
+			}
+			members = append(members, Member{Email: m.PreferredMemberKey.Id, IsGroup: m.Type == "GROUP"})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list members of group %s: %s", group, err)
+	}
+	return members, nil
+}
@@ -0,0 +1,42 @@
+package groups
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/BurntSushi/toml"
+)
+
+// FileResolver resolves group members from a static file for offline use, like:
+//
+//	[Groups]
+//	"group1@email.com" = ["user1@email.com", "group2@email.com"]
+//	"group2@email.com" = ["user2@email.com"]
+//
+// A member is taken as a group if it's listed as a group in the file.
+type FileResolver struct {
+	Groups map[string][]string `toml:"Groups"`
+}
+
+// LoadFileResolver reads the groups file.
+func LoadFileResolver(file string) (*FileResolver, error) {
+	var r FileResolver
+	if _, err := toml.DecodeFile(file, &r); err != nil {
+		return nil, fmt.Errorf("failed to load groups file: %s", err)
+	}
+	return &r, nil
+}
+
+func (r *FileResolver) Members(ctx context.Context, group string) ([]Member, error) {
+	emails, ok := r.Groups[group]
+	if !ok {
+		return nil, fmt.Errorf("group %s is not in the groups file", group)
+	}
+
+	var members []Member
+	for _, email := range emails {
+		_, isGroup := r.Groups[email]
+		members = append(members, Member{Email: email, IsGroup: isGroup})
+	}
+	return members, nil
+}
@@ -0,0 +1,75 @@
+package groups
+
+import (
+	"context"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Member is a direct member of a group.
+type Member struct {
+	Email   string
+	IsGroup bool
+}
+
+// Resolver returns the direct members of a group.
+type Resolver interface {
+	Members(ctx context.Context, group string) ([]Member, error)
+}
+
+// Expander finds the groups a member belongs to directly or through nested groups.
+// It caches the members of each group, so one Expander should be used for a query.
+type Expander struct {
+	resolver Resolver
+	members  map[string][]Member
+}
+
+func NewExpander(r Resolver) *Expander {
+	return &Expander{resolver: r, members: map[string][]Member{}}
+}
+
+// Path returns the chain of groups from group to the group that has member as a direct member,
+// like [group, nested-group]. It returns nil if member doesn't belong to group.
+// A group that can't be resolved is taken as empty.
+func (e *Expander) Path(ctx context.Context, group, member string) []string {
+	return e.path(ctx, group, member, map[string]bool{})
+}
+
+func (e *Expander) path(ctx context.Context, group, member string, visited map[string]bool) []string {
+	key := strings.ToLower(group)
+	if visited[key] {
+		return nil // nested groups may form a cycle
+	}
+	visited[key] = true
+
+	members := e.membersOf(ctx, group)
+	for _, m := range members {
+		if strings.EqualFold(m.Email, member) {
+			return []string{group}
+		}
+	}
+	for _, m := range members {
+		if !m.IsGroup {
+			continue
+		}
+		if p := e.path(ctx, m.Email, member, visited); p != nil {
+			return append([]string{group}, p...)
+		}
+	}
+	return nil
+}
+
+func (e *Expander) membersOf(ctx context.Context, group string) []Member {
+	key := strings.ToLower(group)
+	if members, ok := e.members[key]; ok {
+		return members
+	}
+
+	members, err := e.resolver.Members(ctx, group)
+	if err != nil {
+		log.Warn().Msgf("failed to resolve members of group %s, taken as empty: %s", group, err)
+	}
+	e.members[key] = members
+	return members
+}
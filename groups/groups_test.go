@@ -0,0 +1,67 @@
+package groups
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpanderPath(t *testing.T) {
+	r := &FileResolver{Groups: map[string][]string{
+		"team@email.com":   {"user1@email.com", "sub@email.com"},
+		"sub@email.com":    {"user2@email.com", "team@email.com"}, // cycle
+		"other@email.com":  {"user3@email.com"},
+		"nested@email.com": {"unknown@email.com", "sub@email.com"},
+	}}
+
+	cases := []struct {
+		name   string
+		group  string
+		member string
+		want   []string
+	}{
+		{name: "direct", group: "team@email.com", member: "user1@email.com", want: []string{"team@email.com"}},
+		{name: "nested", group: "team@email.com", member: "user2@email.com", want: []string{"team@email.com", "sub@email.com"}},
+		{name: "case insensitive", group: "team@email.com", member: "User1@Email.com", want: []string{"team@email.com"}},
+		{name: "not a member", group: "team@email.com", member: "user3@email.com", want: nil},
+		{name: "unknown group", group: "missing@email.com", member: "user1@email.com", want: nil},
+		{name: "deep", group: "nested@email.com", member: "user1@email.com", want: []string{"nested@email.com", "sub@email.com", "team@email.com"}},
+	}
+
+	e := NewExpander(r)
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := e.Path(context.Background(), c.group, c.member)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got: %v, want: %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestLoadFileResolver(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "groups.toml")
+	content := `[Groups]
+"team@email.com" = ["user1@email.com", "sub@email.com"]
+"sub@email.com" = ["user2@email.com"]
+`
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := LoadFileResolver(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := r.Members(context.Background(), "team@email.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []Member{{Email: "user1@email.com"}, {Email: "sub@email.com", IsGroup: true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+}
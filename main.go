@@ -17,6 +17,13 @@ package main
 
 import "github.com/hirosassa/bqiam/cmd"
 
+// set by ldflags on release
+var (
+	Version  = "dev"
+	Revision = "unknown"
+)
+
 func main() {
+	cmd.SetVersion(Version, Revision)
 	cmd.Execute()
 }
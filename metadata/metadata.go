@@ -1,39 +1,88 @@
 package metadata
 
 import (
-	"fmt"
-	"os"
+	"time"
 
 	bq "cloud.google.com/go/bigquery"
-	"github.com/BurntSushi/toml"
 )
 
 type Metas struct {
-	Metas []Meta `toml:"Metas"`
+	Header   Header    `toml:"Header"`
+	Metas    []Meta    `toml:"Metas"`
+	Bindings []Binding `toml:"Bindings"`
+	Datasets []Dataset `toml:"Datasets"`
+}
+
+const (
+	StatusOK     = "ok"
+	StatusFailed = "failed"
+)
+
+// Header describes when, by which version and for which projects the cache is made.
+// It's empty in caches made by older versions.
+type Header struct {
+	CreatedAt time.Time       `toml:"CreatedAt"`
+	Version   string          `toml:"Version"`
+	Projects  []ProjectStatus `toml:"Projects"`
+}
+
+// ProjectStatus is the result of fetching a project.
+// The entries of a failed project are kept from the previous cache, fetched at FetchedAt if any.
+type ProjectStatus struct {
+	Project   string    `toml:"Project"`
+	Status    string    `toml:"Status"` // StatusOK or StatusFailed
+	Error     string    `toml:"Error,omitempty"`
+	FetchedAt time.Time `toml:"FetchedAt,omitempty"`
+}
+
+// Project returns the status of the project, or false if the cache doesn't cover it.
+func (h Header) Project(project string) (ProjectStatus, bool) {
+	for _, p := range h.Projects {
+		if p.Project == project {
+			return p, true
+		}
+	}
+	return ProjectStatus{}, false
 }
 
 type Meta struct {
-	Project string        `toml:"Project"`
-	Dataset string        `toml:"Dataset"`
-	Role    bq.AccessRole `toml:"Role"`
-	Entity  string        `toml:"Entity"`
+	Project    string        `toml:"Project"`
+	Dataset    string        `toml:"Dataset"`
+	Role       bq.AccessRole `toml:"Role"`
+	EntityType string        `toml:"EntityType"` // like "user" or "group", see bqrole.EntityTypeName
+	Entity     string        `toml:"Entity"`
+}
+
+// Binding is a member of a project-level IAM role.
+type Binding struct {
+	Project   string `toml:"Project"`
+	Role      string `toml:"Role"`
+	Member    string `toml:"Member"` // like user:user1@email.com
+	Condition string `toml:"Condition,omitempty"`
 }
 
-// Load reads cacheFile.
+// Dataset is the version of a dataset whose access entries are cached.
+type Dataset struct {
+	Project      string    `toml:"Project"`
+	Dataset      string    `toml:"Dataset"`
+	ETag         string    `toml:"ETag"`
+	LastModified time.Time `toml:"LastModified"`
+}
+
+// Load reads cacheFile in the format of its extension.
 func (ms *Metas) Load(cacheFile string) error {
-	if _, err := toml.DecodeFile(cacheFile, ms); err != nil {
-		return fmt.Errorf("failed to load medadata cache file: %v\n  (use `bqiam cache` to create or update bigquery datasts' metadata)", err)
+	s, err := NewStorage(FormatOf(cacheFile))
+	if err != nil {
+		return err
 	}
-	return nil
+	return s.Load(cacheFile, ms)
 }
 
-// Save stores the cache data to the file
+// Save stores the cache data to the file atomically, in the format of its extension.
 func (ms *Metas) Save(cacheFile string) error {
-	f, err := os.Create(cacheFile)
+	s, err := NewStorage(FormatOf(cacheFile))
 	if err != nil {
-		return fmt.Errorf("failed to save metadata to the file. err: %s", err)
+		return err
 	}
-	defer f.Close()
-
-	return toml.NewEncoder(f).Encode(ms)
+	return s.Save(cacheFile, ms)
 }
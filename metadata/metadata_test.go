@@ -0,0 +1,40 @@
+package metadata
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+)
+
+func TestSaveLoad(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ms := Metas{
+		Header: Header{
+			CreatedAt: created,
+			Version:   "v1.0.0",
+			Projects: []ProjectStatus{
+				{Project: "prj1", Status: StatusOK, FetchedAt: created},
+				{Project: "prj2", Status: StatusFailed, Error: "permission denied"},
+			},
+		},
+		Metas:    []Meta{{Project: "prj1", Dataset: "ds1", Role: bq.ReaderRole, EntityType: "user", Entity: "user1@email.com"}},
+		Bindings: []Binding{{Project: "prj1", Role: "roles/viewer", Member: "user:user1@email.com"}},
+		Datasets: []Dataset{{Project: "prj1", Dataset: "ds1", ETag: "etag1", LastModified: created}},
+	}
+
+	file := filepath.Join(t.TempDir(), "cache.toml")
+	if err := ms.Save(file); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got Metas
+	if err := got.Load(file); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, ms) {
+		t.Errorf("got: %+v\nwant: %+v", got, ms)
+	}
+}
@@ -0,0 +1,340 @@
+package metadata
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	_ "modernc.org/sqlite" // register the pure Go driver, as the release builds disable cgo
+
+	"github.com/hirosassa/bqiam/atomicfile"
+)
+
+// sqliteSchema is the schema of the SQLite cache, also documented for `bqiam sql`.
+const sqliteSchema = `
+CREATE TABLE header (created_at TEXT, version TEXT);
+CREATE TABLE projects (project TEXT PRIMARY KEY, status TEXT, error TEXT, fetched_at TEXT);
+CREATE TABLE datasets (project TEXT, dataset TEXT, etag TEXT, last_modified TEXT, PRIMARY KEY (project, dataset));
+CREATE TABLE acl (project TEXT, dataset TEXT, role TEXT, entity_type TEXT, entity TEXT);
+CREATE INDEX acl_entity ON acl (entity);
+CREATE INDEX acl_dataset ON acl (project, dataset);
+CREATE TABLE bindings (project TEXT, role TEXT, member TEXT, condition TEXT);
+CREATE INDEX bindings_member ON bindings (member);
+CREATE INDEX bindings_project ON bindings (project);
+`
+
+// SaveSQLite stores the cache data to the SQLite database file atomically.
+func (ms *Metas) SaveSQLite(cacheFile string) error {
+	err := atomicfile.WritePath(cacheFile, func(tmp string) error {
+		dsn, err := sqliteURI(tmp, "")
+		if err != nil {
+			return err
+		}
+		db, err := sql.Open("sqlite", dsn)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		if _, err := db.Exec(sqliteSchema); err != nil {
+			return fmt.Errorf("failed to create tables: %s", err)
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback() //nolint:errcheck // no-op after commit
+
+		if err := ms.insert(tx); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		return db.Close()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save metadata to the file. err: %s", err)
+	}
+	return nil
+}
+
+func (ms *Metas) insert(tx *sql.Tx) error {
+	if _, err := tx.Exec(`INSERT INTO header VALUES (?, ?)`, formatTime(ms.Header.CreatedAt), ms.Header.Version); err != nil {
+		return fmt.Errorf("failed to insert header: %s", err)
+	}
+
+	for _, p := range ms.Header.Projects {
+		if _, err := tx.Exec(`INSERT INTO projects VALUES (?, ?, ?, ?)`, p.Project, p.Status, p.Error, formatTime(p.FetchedAt)); err != nil {
+			return fmt.Errorf("failed to insert project %s: %s", p.Project, err)
+		}
+	}
+
+	for _, d := range ms.Datasets {
+		if _, err := tx.Exec(`INSERT INTO datasets VALUES (?, ?, ?, ?)`, d.Project, d.Dataset, d.ETag, formatTime(d.LastModified)); err != nil {
+			return fmt.Errorf("failed to insert dataset %s.%s: %s", d.Project, d.Dataset, err)
+		}
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO acl VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, m := range ms.Metas {
+		if _, err := stmt.Exec(m.Project, m.Dataset, string(m.Role), m.EntityType, m.Entity); err != nil {
+			return fmt.Errorf("failed to insert access entry of %s.%s: %s", m.Project, m.Dataset, err)
+		}
+	}
+
+	for _, b := range ms.Bindings {
+		if _, err := tx.Exec(`INSERT INTO bindings VALUES (?, ?, ?, ?)`, b.Project, b.Role, b.Member, b.Condition); err != nil {
+			return fmt.Errorf("failed to insert binding of %s: %s", b.Project, err)
+		}
+	}
+	return nil
+}
+
+// Filter selects the entries to load from the SQLite cache with its indexes. Empty fields match everything.
+// It may select more entries than asked (e.g. the dataset prefix ignores case), so callers should filter them again.
+type Filter struct {
+	Projects      []string
+	Dataset       string // exact dataset name
+	DatasetPrefix string
+	Roles         []bq.AccessRole // roles of the access entries
+	EntityTypes   []string        // entity types of the access entries
+	Entities      []string        // exact entities of the access entries
+	BindingRoles  []string        // project roles of the bindings
+	Members       []string        // exact members of the bindings
+}
+
+// LoadSQLite reads the SQLite database file.
+func (ms *Metas) LoadSQLite(cacheFile string) error {
+	return ms.LoadSQLiteFiltered(cacheFile, Filter{})
+}
+
+// LoadSQLiteFiltered reads the header and the entries selected by f from the SQLite database file,
+// instead of loading all the entries into memory.
+// The datasets are selected only by the project and dataset fields of f.
+func (ms *Metas) LoadSQLiteFiltered(cacheFile string, f Filter) error {
+	db, err := openSQLite(cacheFile)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := ms.load(db, f); err != nil {
+		return fmt.Errorf("failed to load medadata cache file: %v\n  (use `bqiam cache` to create or update bigquery datasts' metadata)", err)
+	}
+	return nil
+}
+
+func (ms *Metas) load(db *sql.DB, f Filter) error {
+	var createdAt string
+	if err := db.QueryRow(`SELECT created_at, version FROM header`).Scan(&createdAt, &ms.Header.Version); err != nil {
+		return err
+	}
+	ms.Header.CreatedAt = parseTime(createdAt)
+
+	err := queryRows(db, `SELECT project, status, error, fetched_at FROM projects ORDER BY rowid`, nil, func(rows *sql.Rows) error {
+		var p ProjectStatus
+		var fetchedAt string
+		if err := rows.Scan(&p.Project, &p.Status, &p.Error, &fetchedAt); err != nil {
+			return err
+		}
+		p.FetchedAt = parseTime(fetchedAt)
+		ms.Header.Projects = append(ms.Header.Projects, p)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var datasets where
+	in(&datasets, "project", f.Projects)
+	datasets.datasetIs(f.Dataset, f.DatasetPrefix)
+	err = queryRows(db, `SELECT project, dataset, etag, last_modified FROM datasets`+datasets.String()+` ORDER BY rowid`, datasets.args, func(rows *sql.Rows) error {
+		var d Dataset
+		var lastModified string
+		if err := rows.Scan(&d.Project, &d.Dataset, &d.ETag, &lastModified); err != nil {
+			return err
+		}
+		d.LastModified = parseTime(lastModified)
+		ms.Datasets = append(ms.Datasets, d)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var acl where
+	in(&acl, "project", f.Projects)
+	acl.datasetIs(f.Dataset, f.DatasetPrefix)
+	in(&acl, "role", f.Roles)
+	in(&acl, "entity_type", f.EntityTypes)
+	in(&acl, "entity", f.Entities)
+	err = queryRows(db, `SELECT project, dataset, role, entity_type, entity FROM acl`+acl.String()+` ORDER BY rowid`, acl.args, func(rows *sql.Rows) error {
+		var m Meta
+		var role string
+		if err := rows.Scan(&m.Project, &m.Dataset, &role, &m.EntityType, &m.Entity); err != nil {
+			return err
+		}
+		m.Role = bq.AccessRole(role)
+		ms.Metas = append(ms.Metas, m)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var bindings where
+	in(&bindings, "project", f.Projects)
+	in(&bindings, "role", f.BindingRoles)
+	in(&bindings, "member", f.Members)
+	return queryRows(db, `SELECT project, role, member, condition FROM bindings`+bindings.String()+` ORDER BY rowid`, bindings.args, func(rows *sql.Rows) error {
+		var b Binding
+		if err := rows.Scan(&b.Project, &b.Role, &b.Member, &b.Condition); err != nil {
+			return err
+		}
+		ms.Bindings = append(ms.Bindings, b)
+		return nil
+	})
+}
+
+// QuerySQLite runs the read-only query against the SQLite cache, and returns the column names and the rows.
+func QuerySQLite(cacheFile, query string) ([]string, [][]any, error) {
+	db, err := openSQLite(cacheFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to run query: %s", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var result [][]any
+	for rows.Next() {
+		values := make([]any, len(columns))
+		ptrs := make([]any, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, nil, err
+		}
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		result = append(result, values)
+	}
+	return columns, result, rows.Err()
+}
+
+// openSQLite opens the SQLite cache read-only.
+func openSQLite(cacheFile string) (*sql.DB, error) {
+	dsn, err := sqliteURI(cacheFile, "mode=ro")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open medadata cache file: %s", err)
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open medadata cache file: %s", err)
+	}
+	return db, nil
+}
+
+// sqliteURI returns the URI of the file with the query, escaping the characters like "?", "#" and "%" in the path,
+// which the driver would take as the query otherwise.
+func sqliteURI(file, query string) (string, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return "", err
+	}
+	p := filepath.ToSlash(abs)
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p // like /C:/path on Windows
+	}
+	u := url.URL{Scheme: "file", Path: p, RawQuery: query}
+	return u.String(), nil
+}
+
+func queryRows(db *sql.DB, query string, args []any, scan func(*sql.Rows) error) error {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// where builds the WHERE clause of a query from the conditions joined by AND.
+type where struct {
+	conds []string
+	args  []any
+}
+
+// in adds the condition that column is one of values, or nothing if values is empty.
+func in[T ~string](w *where, column string, values []T) {
+	if len(values) == 0 {
+		return
+	}
+	w.conds = append(w.conds, column+" IN ("+strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")+")")
+	for _, v := range values {
+		w.args = append(w.args, string(v))
+	}
+}
+
+// datasetIs adds the conditions that the dataset is dataset and starts with prefix, if they're not empty.
+func (w *where) datasetIs(dataset, prefix string) {
+	if dataset != "" {
+		w.conds = append(w.conds, "dataset = ?")
+		w.args = append(w.args, dataset)
+	}
+	if prefix != "" {
+		w.conds = append(w.conds, `dataset LIKE ? ESCAPE '\'`)
+		w.args = append(w.args, likeEscaper.Replace(prefix)+"%")
+	}
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func (w *where) String() string {
+	if len(w.conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(w.conds, " AND ")
+}
+
+// formatTime formats t for the TEXT columns, leaving the zero time empty.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func parseTime(s string) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, s)
+	return t
+}
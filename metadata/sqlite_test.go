@@ -0,0 +1,173 @@
+package metadata
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+)
+
+func TestSaveLoadSQLite(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ms := Metas{
+		Header: Header{
+			CreatedAt: created,
+			Version:   "v1.0.0",
+			Projects: []ProjectStatus{
+				{Project: "prj1", Status: StatusOK, FetchedAt: created},
+				{Project: "prj2", Status: StatusFailed, Error: "permission denied"},
+			},
+		},
+		Metas: []Meta{
+			{Project: "prj1", Dataset: "ds1", Role: bq.ReaderRole, EntityType: "user", Entity: "user1@email.com"},
+			{Project: "prj1", Dataset: "ds1", Role: bq.OwnerRole, EntityType: "specialGroup", Entity: "projectOwners"},
+		},
+		Bindings: []Binding{
+			{Project: "prj1", Role: "roles/viewer", Member: "user:user1@email.com"},
+			{Project: "prj1", Role: "roles/editor", Member: "group:group1@email.com", Condition: "expires"},
+		},
+		Datasets: []Dataset{{Project: "prj1", Dataset: "ds1", ETag: "etag1", LastModified: created}},
+	}
+
+	file := filepath.Join(t.TempDir(), "cache.db")
+	if err := ms.SaveSQLite(file); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got Metas
+	if err := got.LoadSQLite(file); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, ms) {
+		t.Errorf("got: %+v\nwant: %+v", got, ms)
+	}
+
+	// overwrite the existing database
+	ms.Metas = ms.Metas[:1]
+	if err := ms.SaveSQLite(file); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	columns, rows, err := QuerySQLite(file, "SELECT a.entity, b.role FROM acl a JOIN bindings b ON b.member = 'user:' || a.entity")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"entity", "role"}; !reflect.DeepEqual(columns, want) {
+		t.Errorf("columns got: %v, want: %v", columns, want)
+	}
+	if want := [][]any{{"user1@email.com", "roles/viewer"}}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows got: %v, want: %v", rows, want)
+	}
+}
+
+func TestLoadSQLiteSpecialPath(t *testing.T) {
+	ms := Metas{Header: Header{Version: "v1.0.0"}}
+	for _, name := range []string{"cache?mode=rw.db", "cache#1.db", "cache%20.db", "cache 1.db"} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			file := filepath.Join(dir, name)
+			if err := ms.SaveSQLite(file); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var got Metas
+			if err := got.LoadSQLite(file); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, ms) {
+				t.Errorf("got: %+v, want: %+v", got, ms)
+			}
+			if _, _, err := QuerySQLite(file, "DELETE FROM acl"); err == nil {
+				t.Error("expected an error on writing to the cache")
+			}
+
+			// no other file is opened or created
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				if e.Name() != name && e.Name() != name+".lock" {
+					t.Errorf("unexpected file: %s", e.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestQuerySQLiteReadOnly(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cache.db")
+	if err := (&Metas{}).SaveSQLite(file); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, _, err := QuerySQLite(file, "DELETE FROM acl"); err == nil {
+		t.Error("expected an error on writing to the cache")
+	}
+}
+
+func TestLoadSQLiteFiltered(t *testing.T) {
+	ms := Metas{
+		Header: Header{Version: "v1.0.0", Projects: []ProjectStatus{{Project: "prj1", Status: StatusOK}, {Project: "prj2", Status: StatusOK}}},
+		Metas: []Meta{
+			{Project: "prj1", Dataset: "staging_ds1", Role: bq.ReaderRole, EntityType: "user", Entity: "user1@email.com"},
+			{Project: "prj1", Dataset: "staging_ds1", Role: bq.WriterRole, EntityType: "user", Entity: "user2@email.com"},
+			{Project: "prj1", Dataset: "ds2", Role: bq.ReaderRole, EntityType: "user", Entity: "user1@email.com"},
+			{Project: "prj2", Dataset: "staging_ds3", Role: bq.ReaderRole, EntityType: "user", Entity: "user1@email.com"},
+		},
+		Bindings: []Binding{
+			{Project: "prj1", Role: "roles/viewer", Member: "user:user1@email.com"},
+			{Project: "prj1", Role: "roles/bigquery.jobUser", Member: "user:user1@email.com"},
+			{Project: "prj1", Role: "roles/viewer", Member: "user:user2@email.com"},
+			{Project: "prj2", Role: "roles/viewer", Member: "user:user1@email.com"},
+		},
+		Datasets: []Dataset{
+			{Project: "prj1", Dataset: "staging_ds1"},
+			{Project: "prj1", Dataset: "ds2"},
+			{Project: "prj2", Dataset: "staging_ds3"},
+		},
+	}
+	file := filepath.Join(t.TempDir(), "cache.db")
+	if err := ms.SaveSQLite(file); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	f := Filter{
+		Projects:      []string{"prj1"},
+		DatasetPrefix: "staging_",
+		Roles:         []bq.AccessRole{bq.ReaderRole},
+		EntityTypes:   []string{"user"},
+		Entities:      []string{"user1@email.com"},
+		BindingRoles:  []string{"roles/viewer"},
+		Members:       []string{"user:user1@email.com"},
+	}
+	var got Metas
+	if err := got.LoadSQLiteFiltered(file, f); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := Metas{
+		Header:   ms.Header,
+		Metas:    ms.Metas[:1],
+		Bindings: ms.Bindings[:1],
+		Datasets: ms.Datasets[:1],
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %+v\nwant: %+v", got, want)
+	}
+}
+
+func TestWhere(t *testing.T) {
+	var w where
+	in(&w, "entity", []string{"user1@email.com", "user2@email.com"})
+	in(&w, "role", []bq.AccessRole{})
+	w.datasetIs("", "staging_100%")
+
+	if want := ` WHERE entity IN (?, ?) AND dataset LIKE ? ESCAPE '\'`; w.String() != want {
+		t.Errorf("got: %s, want: %s", w.String(), want)
+	}
+	if want := []any{"user1@email.com", "user2@email.com", `staging\_100\%%`}; !reflect.DeepEqual(w.args, want) {
+		t.Errorf("got: %v, want: %v", w.args, want)
+	}
+}
@@ -0,0 +1,154 @@
+package metadata
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/hirosassa/bqiam/atomicfile"
+)
+
+// Formats of the cache file.
+const (
+	FormatTOML     = "toml"
+	FormatJSON     = "json"
+	FormatJSONGzip = "json.gz" // gzip compressed JSON
+	FormatSQLite   = "sqlite"
+)
+
+// Formats lists the supported formats.
+var Formats = []string{FormatTOML, FormatJSON, FormatJSONGzip, FormatSQLite}
+
+// FormatOf returns the format of the file from its extension, which is TOML for unknown extensions.
+func FormatOf(file string) string {
+	switch {
+	case strings.HasSuffix(file, ".json.gz"), strings.HasSuffix(file, ".gz"):
+		return FormatJSONGzip
+	case strings.HasSuffix(file, ".json"):
+		return FormatJSON
+	case strings.HasSuffix(file, ".db"), strings.HasSuffix(file, ".sqlite"), strings.HasSuffix(file, ".sqlite3"):
+		return FormatSQLite
+	}
+	return FormatTOML
+}
+
+// Storage loads and saves the cache in a format.
+type Storage interface {
+	Load(file string, ms *Metas) error
+	Save(file string, ms *Metas) error
+}
+
+// NewStorage returns the storage of the format.
+func NewStorage(format string) (Storage, error) {
+	if format == FormatSQLite {
+		return sqliteStorage{}, nil
+	}
+	codec, err := NewCodec(format)
+	if err != nil {
+		return nil, err
+	}
+	return fileStorage{codec: codec}, nil
+}
+
+// fileStorage stores the cache as a single document encoded by codec.
+type fileStorage struct {
+	codec Codec
+}
+
+func (s fileStorage) Load(file string, ms *Metas) error {
+	if err := ReadFile(file, s.codec, ms); err != nil {
+		return fmt.Errorf("failed to load medadata cache file: %v\n  (use `bqiam cache` to create or update bigquery datasts' metadata)", err)
+	}
+	return nil
+}
+
+func (s fileStorage) Save(file string, ms *Metas) error {
+	if err := WriteFile(file, s.codec, ms); err != nil {
+		return fmt.Errorf("failed to save metadata to the file. err: %s", err)
+	}
+	return nil
+}
+
+type sqliteStorage struct{}
+
+func (sqliteStorage) Load(file string, ms *Metas) error { return ms.LoadSQLite(file) }
+func (sqliteStorage) Save(file string, ms *Metas) error { return ms.SaveSQLite(file) }
+
+// Codec encodes and decodes a value as a document in a format.
+type Codec interface {
+	Encode(w io.Writer, v any) error
+	Decode(r io.Reader, v any) error
+}
+
+// NewCodec returns the codec of the format, which must be a document format (not SQLite).
+func NewCodec(format string) (Codec, error) {
+	switch format {
+	case FormatTOML:
+		return tomlCodec{}, nil
+	case FormatJSON:
+		return jsonCodec{}, nil
+	case FormatJSONGzip:
+		return gzipCodec{jsonCodec{}}, nil
+	case FormatSQLite:
+		return nil, fmt.Errorf("%s is not supported for this file", format)
+	}
+	return nil, fmt.Errorf("unknown format: %s (must be one of %s)", format, strings.Join(Formats, ", "))
+}
+
+// ReadFile decodes the file into v.
+func ReadFile(file string, c Codec, v any) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.Decode(f, v)
+}
+
+// WriteFile encodes v into the file atomically.
+func WriteFile(file string, c Codec, v any) error {
+	return atomicfile.Write(file, func(w io.Writer) error {
+		return c.Encode(w, v)
+	})
+}
+
+type tomlCodec struct{}
+
+func (tomlCodec) Encode(w io.Writer, v any) error { return toml.NewEncoder(w).Encode(v) }
+
+func (tomlCodec) Decode(r io.Reader, v any) error {
+	_, err := toml.NewDecoder(r).Decode(v)
+	return err
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(w io.Writer, v any) error { return json.NewEncoder(w).Encode(v) }
+func (jsonCodec) Decode(r io.Reader, v any) error { return json.NewDecoder(r).Decode(v) }
+
+// gzipCodec compresses the document of the underlying codec.
+type gzipCodec struct {
+	Codec
+}
+
+func (c gzipCodec) Encode(w io.Writer, v any) error {
+	gw := gzip.NewWriter(w)
+	if err := c.Codec.Encode(gw, v); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func (c gzipCodec) Decode(r io.Reader, v any) error {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+	return c.Codec.Decode(gr, v)
+}
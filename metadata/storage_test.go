@@ -0,0 +1,96 @@
+package metadata
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+)
+
+func TestFormatOf(t *testing.T) {
+	cases := []struct {
+		file string
+		want string
+	}{
+		{file: "cache.toml", want: FormatTOML},
+		{file: "cache", want: FormatTOML},
+		{file: "cache.json", want: FormatJSON},
+		{file: "cache.json.gz", want: FormatJSONGzip},
+		{file: "cache.gz", want: FormatJSONGzip},
+		{file: "cache.db", want: FormatSQLite},
+		{file: "cache.sqlite3", want: FormatSQLite},
+	}
+
+	for _, c := range cases {
+		if got := FormatOf(c.file); got != c.want {
+			t.Errorf("FormatOf(%s) got: %s, want: %s", c.file, got, c.want)
+		}
+	}
+}
+
+func TestStorage(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ms := Metas{
+		Header: Header{
+			CreatedAt: created,
+			Version:   "v1.0.0",
+			Projects:  []ProjectStatus{{Project: "prj1", Status: StatusOK, FetchedAt: created}},
+		},
+		Metas:    []Meta{{Project: "prj1", Dataset: "ds1", Role: bq.ReaderRole, EntityType: "user", Entity: "user1@email.com"}},
+		Bindings: []Binding{{Project: "prj1", Role: "roles/viewer", Member: "user:user1@email.com"}},
+		Datasets: []Dataset{{Project: "prj1", Dataset: "ds1", ETag: "etag1", LastModified: created}},
+	}
+
+	for _, format := range Formats {
+		t.Run(format, func(t *testing.T) {
+			s, err := NewStorage(format)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			file := filepath.Join(t.TempDir(), "cache")
+			if err := s.Save(file, &ms); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var got Metas
+			if err := s.Load(file, &got); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, ms) {
+				t.Errorf("got: %+v\nwant: %+v", got, ms)
+			}
+		})
+	}
+
+	if _, err := NewStorage("xml"); err == nil {
+		t.Error("expected an error on unknown format")
+	}
+}
+
+func TestGzipIsCompressed(t *testing.T) {
+	dir := t.TempDir()
+	ms := Metas{}
+	for i := 0; i < 100; i++ {
+		ms.Metas = append(ms.Metas, Meta{Project: "prj1", Dataset: "ds1", Role: bq.ReaderRole, EntityType: "user", Entity: "user1@email.com"})
+	}
+
+	sizes := map[string]int64{}
+	for _, file := range []string{"cache.json", "cache.json.gz"} {
+		path := filepath.Join(dir, file)
+		if err := ms.Save(path); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		sizes[file] = info.Size()
+	}
+	if sizes["cache.json.gz"] >= sizes["cache.json"] {
+		t.Errorf("gzip is not smaller than JSON: %v", sizes)
+	}
+}